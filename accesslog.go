@@ -0,0 +1,254 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/goadesign/goa"
+
+	"golang.org/x/net/context"
+)
+
+// LogFormat is the enum that lists the formats supported by the LogRequest middleware.
+type LogFormat int
+
+const (
+	// LogFormatKeyValue logs a "started" and a "completed" entry for each request using the
+	// goa logger key/value pairs.
+	LogFormatKeyValue LogFormat = iota
+	// LogFormatCommon logs a single line per request using the Apache Common Log Format.
+	LogFormatCommon
+	// LogFormatCombined logs a single line per request using the Apache Combined Log Format.
+	LogFormatCombined
+	// LogFormatJSON logs a single JSON object per request, see AccessLogEntry for the fields.
+	LogFormatJSON
+	// LogFormatTemplate logs a single line per request rendered with the LogSpecification
+	// Template.
+	LogFormatTemplate
+)
+
+// clfTimeFormat is the time layout used by the Apache Common and Combined Log Formats.
+const clfTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+// LogSpecification describes how the LogRequest middleware logs requests.
+type LogSpecification struct {
+	// Verbose is a flag that determines whether the request params and payload are logged.
+	Verbose bool
+	// Format is the access log format.
+	// Defaults to LogFormatKeyValue.
+	Format LogFormat
+	// Template renders the access log lines when Format is LogFormatTemplate, it is executed
+	// with a *AccessLogEntry.
+	Template *template.Template
+	// Output receives the access log lines, one line per request. It is not used with
+	// LogFormatKeyValue.
+	// Optional, lines are logged with the goa logger if nil.
+	Output io.Writer
+	// TrustProxyHeaders is a flag that determines whether the client IP is read from the
+	// X-Forwarded-For and X-Real-Ip headers. Only set it if the service runs behind a proxy
+	// that sets these headers.
+	// Defaults to false, the client IP is the request remote address.
+	TrustProxyHeaders bool
+	// LogHeaders is a flag that determines whether the request headers are logged when
+	// Verbose is true.
+	LogHeaders bool
+	// Redaction describes the sensitive data removed from the logged URLs, headers, params
+	// and payloads.
+	// Optional, nothing is redacted if nil.
+	Redaction *Redaction
+	// Sampling selects the requests that are logged.
+	// Optional, all requests are logged if nil.
+	Sampling *LogSampling
+	// ErrorStatus is the response status from which requests are logged at error level
+	// instead of info level, e.g. 500.
+	// Optional, all requests are logged at info level if 0.
+	ErrorStatus int
+
+	// mu serializes writes to Output.
+	mu sync.Mutex
+}
+
+// AccessLogEntry holds the request and response properties logged by LogRequest in the access
+// log formats. It is the data given to the LogSpecification Template.
+type AccessLogEntry struct {
+	// Time is the time the request was received.
+	Time time.Time
+	// ClientIP is the IP address of the client.
+	ClientIP string
+	// User is the user name given with basic authentication if any.
+	User string
+	// Method is the request HTTP method.
+	Method string
+	// URI is the request URI.
+	URI string
+	// Proto is the request protocol, e.g. "HTTP/1.1".
+	Proto string
+	// Status is the response status code.
+	Status int
+	// Bytes is the length of the response body.
+	Bytes int
+	// Latency is the time it took to handle the request.
+	Latency time.Duration
+	// UserAgent is the value of the request User-Agent header.
+	UserAgent string
+	// Referer is the value of the request Referer header.
+	Referer string
+	// RequestID is the request ID set by the RequestID middleware or generated by LogRequest.
+	RequestID string
+	// TraceID is the trace ID set by the Trace middleware if any.
+	TraceID string
+	// SpanID is the span ID set by the Trace middleware if any.
+	SpanID string
+}
+
+// jsonAccessLogEntry is the JSON representation of an access log entry. All the fields are
+// always present so that the schema remains stable.
+type jsonAccessLogEntry struct {
+	Time      string  `json:"time"`
+	ClientIP  string  `json:"client_ip"`
+	User      string  `json:"user"`
+	Method    string  `json:"method"`
+	URI       string  `json:"uri"`
+	Proto     string  `json:"proto"`
+	Status    int     `json:"status"`
+	Bytes     int     `json:"bytes"`
+	LatencyMS float64 `json:"latency_ms"`
+	UserAgent string  `json:"user_agent"`
+	Referer   string  `json:"referer"`
+	RequestID string  `json:"request_id"`
+	TraceID   string  `json:"trace_id"`
+	SpanID    string  `json:"span_id"`
+}
+
+// newAccessLogEntry builds the access log entry of a completed request.
+func newAccessLogEntry(req *http.Request, resp *goa.ResponseData, reqID string, startedAt time.Time, trustProxy bool) *AccessLogEntry {
+	e := &AccessLogEntry{
+		Time:      startedAt,
+		ClientIP:  clientIP(req, trustProxy),
+		Method:    req.Method,
+		URI:       req.RequestURI,
+		Proto:     req.Proto,
+		Status:    resp.Status,
+		Bytes:     resp.Length,
+		Latency:   time.Since(startedAt),
+		UserAgent: req.UserAgent(),
+		Referer:   req.Referer(),
+		RequestID: reqID,
+	}
+	if e.URI == "" && req.URL != nil {
+		e.URI = req.URL.RequestURI()
+	}
+	if user, _, ok := req.BasicAuth(); ok {
+		e.User = user
+	}
+	return e
+}
+
+// clientIP returns the IP address of the client that made the request.
+func clientIP(req *http.Request, trustProxy bool) string {
+	if trustProxy {
+		if fwd := req.Header.Get("X-Forwarded-For"); fwd != "" {
+			return strings.TrimSpace(strings.Split(fwd, ",")[0])
+		}
+		if ip := req.Header.Get("X-Real-Ip"); ip != "" {
+			return ip
+		}
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// format renders the access log line of the given entry, without trailing newline.
+func (spec *LogSpecification) format(e *AccessLogEntry) ([]byte, error) {
+	switch spec.Format {
+	case LogFormatCommon, LogFormatCombined:
+		var buf bytes.Buffer
+		fmt.Fprintf(&buf, "%s - %s [%s] %q %d %s",
+			orDash(e.ClientIP), orDash(e.User), e.Time.Format(clfTimeFormat),
+			e.Method+" "+e.URI+" "+e.Proto, e.Status, clfBytes(e.Bytes))
+		if spec.Format == LogFormatCombined {
+			fmt.Fprintf(&buf, " %q %q", orDash(e.Referer), orDash(e.UserAgent))
+		}
+		return buf.Bytes(), nil
+	case LogFormatJSON:
+		return json.Marshal(&jsonAccessLogEntry{
+			Time:      e.Time.Format(time.RFC3339Nano),
+			ClientIP:  e.ClientIP,
+			User:      e.User,
+			Method:    e.Method,
+			URI:       e.URI,
+			Proto:     e.Proto,
+			Status:    e.Status,
+			Bytes:     e.Bytes,
+			LatencyMS: float64(e.Latency) / float64(time.Millisecond),
+			UserAgent: e.UserAgent,
+			Referer:   e.Referer,
+			RequestID: e.RequestID,
+			TraceID:   e.TraceID,
+			SpanID:    e.SpanID,
+		})
+	case LogFormatTemplate:
+		if spec.Template == nil {
+			return nil, fmt.Errorf("no access log template")
+		}
+		var buf bytes.Buffer
+		if err := spec.Template.Execute(&buf, e); err != nil {
+			return nil, err
+		}
+		return bytes.TrimRight(buf.Bytes(), "\n"), nil
+	}
+	return nil, fmt.Errorf("unknown access log format %d", spec.Format)
+}
+
+// logAccess writes the access log line of the given entry to the specification output or to
+// the goa logger.
+func (spec *LogSpecification) logAccess(ctx context.Context, e *AccessLogEntry) {
+	line, err := spec.format(e)
+	if err != nil {
+		goa.Error(ctx, "access log", "err", err)
+		return
+	}
+	if spec.Output == nil {
+		if spec.isError(e.Status) {
+			goa.Error(ctx, string(line))
+		} else {
+			goa.Info(ctx, string(line))
+		}
+		return
+	}
+	spec.mu.Lock()
+	defer spec.mu.Unlock()
+	spec.Output.Write(append(line, '\n'))
+}
+
+// isError returns true if requests with the given response status are logged at error level.
+func (spec *LogSpecification) isError(status int) bool {
+	return spec.ErrorStatus > 0 && status >= spec.ErrorStatus
+}
+
+// orDash returns s or "-" if s is empty as done by the Apache log formats for missing values.
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// clfBytes returns the response size as logged by the Apache log formats.
+func clfBytes(n int) string {
+	if n == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%d", n)
+}
@@ -0,0 +1,97 @@
+package middleware_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"text/template"
+
+	"golang.org/x/net/context"
+
+	"github.com/goadesign/goa"
+	"github.com/goadesign/middleware"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("LogRequestMiddleware", func() {
+	var spec *middleware.LogSpecification
+	var output *bytes.Buffer
+	var logger *testLogger
+	var ctx context.Context
+	var req *http.Request
+	var rw *testResponseWriter
+
+	BeforeEach(func() {
+		output = new(bytes.Buffer)
+		spec = &middleware.LogSpecification{Output: output}
+		logger = new(testLogger)
+		service := newService(logger)
+		var err error
+		req, err = http.NewRequest("GET", "/goo?param=value", nil)
+		Ω(err).ShouldNot(HaveOccurred())
+		req.RemoteAddr = "10.0.0.1:4242"
+		req.Header.Set("User-Agent", "test-agent")
+		req.Header.Set("Referer", "http://example.com/")
+		req.Proto = "HTTP/1.1"
+		rw = new(testResponseWriter)
+		ctx = newContext(service, rw, req, nil)
+		ctx = context.WithValue(ctx, middleware.ReqIDKey, "req-1")
+	})
+
+	serve := func() {
+		h := func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+			return goa.Response(ctx).Send(ctx, 200, "ok")
+		}
+		Ω(middleware.LogRequestMiddleware(spec)(h)(ctx, rw, req)).ShouldNot(HaveOccurred())
+	}
+
+	It("logs using the Common Log Format", func() {
+		spec.Format = middleware.LogFormatCommon
+		serve()
+		Ω(output.String()).Should(MatchRegexp(`^10\.0\.0\.1 - - \[[^\]]+\] "GET /goo\?param=value HTTP/1\.1" 200 5\n$`))
+		Ω(logger.InfoEntries).Should(BeEmpty())
+	})
+
+	It("logs using the Combined Log Format", func() {
+		spec.Format = middleware.LogFormatCombined
+		serve()
+		Ω(output.String()).Should(HaveSuffix(`200 5 "http://example.com/" "test-agent"` + "\n"))
+	})
+
+	It("logs JSON lines", func() {
+		spec.Format = middleware.LogFormatJSON
+		req.Header.Set("X-Forwarded-For", "192.168.1.1, 10.0.0.2")
+		spec.TrustProxyHeaders = true
+		serve()
+		Ω(strings.Count(output.String(), "\n")).Should(Equal(1))
+		var entry map[string]interface{}
+		Ω(json.Unmarshal(output.Bytes(), &entry)).ShouldNot(HaveOccurred())
+		Ω(entry["client_ip"]).Should(Equal("192.168.1.1"))
+		Ω(entry["method"]).Should(Equal("GET"))
+		Ω(entry["uri"]).Should(Equal("/goo?param=value"))
+		Ω(entry["status"]).Should(BeEquivalentTo(200))
+		Ω(entry["bytes"]).Should(BeEquivalentTo(5))
+		Ω(entry["user_agent"]).Should(Equal("test-agent"))
+		Ω(entry["referer"]).Should(Equal("http://example.com/"))
+		Ω(entry["request_id"]).Should(Equal("req-1"))
+		Ω(entry).Should(HaveKey("latency_ms"))
+		Ω(entry).Should(HaveKey("time"))
+	})
+
+	It("logs using a template", func() {
+		spec.Format = middleware.LogFormatTemplate
+		spec.Template = template.Must(template.New("log").Parse("{{.RequestID}} {{.Method}} {{.Status}}"))
+		serve()
+		Ω(output.String()).Should(Equal("req-1 GET 200\n"))
+	})
+
+	It("logs with the goa logger when there is no output", func() {
+		spec.Format = middleware.LogFormatCommon
+		spec.Output = nil
+		serve()
+		Ω(logger.InfoEntries).Should(HaveLen(1))
+		Ω(logger.InfoEntries[0].Msg).Should(ContainSubstring(`"GET /goo?param=value HTTP/1.1" 200 5`))
+	})
+})
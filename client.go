@@ -0,0 +1,131 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/goadesign/goa"
+)
+
+// ClientTransport is a http.RoundTripper that propagates the request ID and the trace context
+// stored in the request context by the RequestID and Trace middlewares to outgoing requests. It
+// may also forward the remaining time budget of the request context deadline.
+// Outgoing requests must be created with the context of the incoming request, e.g.:
+//
+//	client := &http.Client{Transport: middleware.NewClientTransport(nil)}
+//	req, _ := http.NewRequest("GET", "http://downstream/resource", nil)
+//	resp, err := client.Do(req.WithContext(ctx))
+type ClientTransport struct {
+	// Base is the round tripper that sends the requests.
+	// Defaults to http.DefaultTransport.
+	Base http.RoundTripper
+	// RequestIDHeader is the name of the header used to transmit the request ID.
+	// Defaults to RequestIDHeader.
+	RequestIDHeader string
+	// TimeoutHeader is the name of the header used to forward the time remaining until the
+	// request context deadline to downstream services, e.g. RequestTimeoutHeader (integer
+	// milliseconds) or GRPCTimeoutHeader (gRPC format).
+	// Optional, the remaining time is not forwarded if empty.
+	TimeoutHeader string
+	// Log describes how outgoing requests are logged, using the same formats as LogRequest.
+	// Optional, outgoing requests are not logged if nil.
+	Log *LogSpecification
+}
+
+// NewClientTransport returns a ClientTransport that sends requests with the given round tripper
+// or http.DefaultTransport if nil.
+func NewClientTransport(base http.RoundTripper) *ClientTransport {
+	return &ClientTransport{Base: base}
+}
+
+// RoundTrip sets the request ID, trace context and timeout headers and sends the request. Headers
+// already set on the request are not overridden. The request given as argument is not modified.
+func (t *ClientTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	header := t.requestIDHeader()
+	r := new(http.Request)
+	*r = *req
+	r.Header = make(http.Header, len(req.Header)+4)
+	for k, v := range req.Header {
+		r.Header[k] = v
+	}
+	if reqID := ctx.Value(ReqIDKey); reqID != nil && r.Header.Get(header) == "" {
+		r.Header.Set(header, fmt.Sprintf("%v", reqID))
+	}
+	if tp := TraceParent(ctx); tp != "" && r.Header.Get(TraceParentHeader) == "" {
+		r.Header.Set(TraceParentHeader, tp)
+		if state, ok := ctx.Value(TraceStateKey).(string); ok {
+			r.Header.Set(TraceStateHeader, state)
+		}
+	}
+
+	if t.TimeoutHeader != "" && r.Header.Get(t.TimeoutHeader) == "" {
+		if deadline, ok := ctx.Deadline(); ok {
+			if remaining := deadline.Sub(time.Now()); remaining > 0 {
+				r.Header.Set(t.TimeoutHeader, formatTimeout(t.TimeoutHeader, remaining))
+			}
+		}
+	}
+
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	startedAt := time.Now()
+	resp, err := base.RoundTrip(r)
+	if t.Log != nil {
+		t.log(r, resp, err, startedAt)
+	}
+	return resp, err
+}
+
+// log logs the outgoing request.
+func (t *ClientTransport) log(req *http.Request, resp *http.Response, err error, startedAt time.Time) {
+	ctx := req.Context()
+	spec := t.Log
+	url := spec.Redaction.redactURL(req.URL)
+	if err != nil {
+		goa.Error(ctx, "outbound failed", "method", req.Method, "url", url, "err", err,
+			"time", time.Since(startedAt).String())
+		return
+	}
+	if spec.Sampling != nil && !spec.Sampling.sampled(req, resp.StatusCode, time.Since(startedAt)) {
+		return
+	}
+	if spec.Format == LogFormatKeyValue {
+		keyvals := []interface{}{"method", req.Method, "url", url, "status", resp.StatusCode,
+			"bytes", resp.ContentLength, "time", time.Since(startedAt).String()}
+		if spec.isError(resp.StatusCode) {
+			goa.Error(ctx, "outbound", keyvals...)
+		} else {
+			goa.Info(ctx, "outbound", keyvals...)
+		}
+		return
+	}
+	e := &AccessLogEntry{
+		Time:      startedAt,
+		Method:    req.Method,
+		URI:       url,
+		Proto:     req.Proto,
+		Status:    resp.StatusCode,
+		Latency:   time.Since(startedAt),
+		UserAgent: spec.Redaction.redactString(req.UserAgent()),
+		Referer:   spec.Redaction.redactString(req.Referer()),
+		RequestID: req.Header.Get(t.requestIDHeader()),
+	}
+	if resp.ContentLength > 0 {
+		e.Bytes = int(resp.ContentLength)
+	}
+	e.TraceID, _ = ctx.Value(TraceIDKey).(string)
+	e.SpanID, _ = ctx.Value(SpanIDKey).(string)
+	spec.logAccess(ctx, e)
+}
+
+// requestIDHeader returns the name of the header used to transmit the request ID.
+func (t *ClientTransport) requestIDHeader() string {
+	if t.RequestIDHeader == "" {
+		return RequestIDHeader
+	}
+	return t.RequestIDHeader
+}
@@ -0,0 +1,129 @@
+package middleware_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/goadesign/middleware"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ClientTransport", func() {
+	var server *httptest.Server
+	var received http.Header
+	var transport *middleware.ClientTransport
+	var logger *testLogger
+	var ctx context.Context
+
+	BeforeEach(func() {
+		server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			received = req.Header
+			rw.Write([]byte("ok"))
+		}))
+		transport = middleware.NewClientTransport(nil)
+		logger = new(testLogger)
+		ctx = newContext(newService(logger), new(testResponseWriter), nil, nil)
+		ctx = context.WithValue(ctx, middleware.ReqIDKey, "req-1")
+		ctx = context.WithValue(ctx, middleware.TraceIDKey, "4bf92f3577b34da6a3ce929d0e0e4736")
+		ctx = context.WithValue(ctx, middleware.SpanIDKey, "00f067aa0ba902b7")
+		ctx = context.WithValue(ctx, middleware.TraceFlagsKey, "01")
+		ctx = context.WithValue(ctx, middleware.TraceStateKey, "vendor=value")
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	do := func(header http.Header) *http.Request {
+		req, err := http.NewRequest("GET", server.URL+"/goo", nil)
+		Ω(err).ShouldNot(HaveOccurred())
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		req = req.WithContext(ctx)
+		resp, err := (&http.Client{Transport: transport}).Do(req)
+		Ω(err).ShouldNot(HaveOccurred())
+		resp.Body.Close()
+		return req
+	}
+
+	It("propagates the request ID and the trace context", func() {
+		req := do(nil)
+		Ω(received.Get("X-Request-Id")).Should(Equal("req-1"))
+		Ω(received.Get("traceparent")).Should(Equal("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"))
+		Ω(received.Get("tracestate")).Should(Equal("vendor=value"))
+		Ω(req.Header).ShouldNot(HaveKey("X-Request-Id"))
+	})
+
+	It("does not override headers set by the caller", func() {
+		transport.RequestIDHeader = "X-Correlation-Id"
+		do(http.Header{"X-Correlation-Id": {"mine"}})
+		Ω(received.Get("X-Correlation-Id")).Should(Equal("mine"))
+	})
+
+	It("logs outgoing requests", func() {
+		transport.Log = &middleware.LogSpecification{}
+		do(nil)
+		Ω(logger.InfoEntries).Should(HaveLen(1))
+		Ω(logger.InfoEntries[0].Msg).Should(Equal("outbound"))
+		Ω(logger.InfoEntries[0].Data[5]).Should(Equal(200))
+	})
+
+	It("logs outgoing requests using the access log formats", func() {
+		output := new(bytes.Buffer)
+		transport.Log = &middleware.LogSpecification{Format: middleware.LogFormatJSON, Output: output}
+		do(nil)
+		var entry map[string]interface{}
+		Ω(json.Unmarshal(output.Bytes(), &entry)).ShouldNot(HaveOccurred())
+		Ω(entry["uri"]).Should(Equal(server.URL + "/goo"))
+		Ω(entry["status"]).Should(BeEquivalentTo(200))
+		Ω(entry["request_id"]).Should(Equal("req-1"))
+		Ω(entry["trace_id"]).Should(Equal("4bf92f3577b34da6a3ce929d0e0e4736"))
+	})
+
+	It("forwards the remaining time budget", func() {
+		transport.TimeoutHeader = middleware.RequestTimeoutHeader
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		do(nil)
+		ms, err := strconv.Atoi(received.Get(middleware.RequestTimeoutHeader))
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(ms).Should(BeNumerically("~", 5000, 1000))
+
+		transport.TimeoutHeader = middleware.GRPCTimeoutHeader
+		do(nil)
+		Ω(received.Get(middleware.GRPCTimeoutHeader)).Should(MatchRegexp(`^\d{1,8}u$`))
+	})
+
+	It("rounds up sub-millisecond time budgets", func() {
+		var sent http.Header
+		transport.Base = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			sent = req.Header
+			return &http.Response{StatusCode: 200, Body: http.NoBody, Request: req}, nil
+		})
+		transport.TimeoutHeader = middleware.RequestTimeoutHeader
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, 900*time.Microsecond)
+		defer cancel()
+		req, err := http.NewRequest("GET", server.URL+"/goo", nil)
+		Ω(err).ShouldNot(HaveOccurred())
+		_, err = transport.RoundTrip(req.WithContext(ctx))
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(sent.Get(middleware.RequestTimeoutHeader)).Should(Equal("1"))
+	})
+})
+
+// roundTripperFunc is a http.RoundTripper implemented by a function.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
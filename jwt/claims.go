@@ -0,0 +1,176 @@
+package jwt
+
+import (
+	"fmt"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// ClaimError is the error returned when a token claim fails validation.
+type ClaimError struct {
+	// Claim is the name of the claim that failed validation.
+	Claim string
+	// Message describes the validation failure.
+	Message string
+}
+
+// Error returns the error message.
+func (e *ClaimError) Error() string {
+	return e.Message
+}
+
+// timeValidationErrors lists the jwt-go validation errors caused by time based claims. These
+// claims are validated by the package so that the specification clock skew gets applied.
+const timeValidationErrors = jwt.ValidationErrorExpired | jwt.ValidationErrorNotValidYet |
+	jwt.ValidationErrorIssuedAt
+
+// parse decrypts the token if needed then parses and validates the token signature and claims.
+func (spec *Specification) parse(tok string) (*jwt.Token, error) {
+	tok, err := spec.unwrap(tok)
+	if err != nil {
+		return nil, err
+	}
+	token, err := jwt.Parse(tok, spec.keyFunc())
+	if err != nil {
+		ve, ok := err.(*jwt.ValidationError)
+		if !ok || ve.Errors&^timeValidationErrors != 0 {
+			return token, err
+		}
+		// The signature is valid, time based claims are validated below.
+	}
+	err = spec.validateClaims(token.Claims, time.Now())
+	token.Valid = err == nil
+	return token, err
+}
+
+// keyFunc returns the function used by jwt-go to retrieve the token validation key. Unless the
+// key comes from the keyring, which checks the algorithm of each key, the function rejects tokens
+// whose algorithm differs from the specification KeySigningMethod. This prevents algorithm
+// confusion attacks where for example a RSA public key is used as HMAC secret.
+func (spec *Specification) keyFunc() jwt.Keyfunc {
+	validationFunc := spec.validationFunc()
+	method := spec.KeySigningMethod
+	if spec.ValidationFunc == nil {
+		method = 0
+	}
+	return func(token *jwt.Token) (interface{}, error) {
+		if method != 0 {
+			if alg, _ := token.Header["alg"].(string); alg != signingmethods[method] {
+				return nil, fmt.Errorf("unexpected signing algorithm %q", alg)
+			}
+		}
+		if validationFunc == nil {
+			return nil, fmt.Errorf("no validation function")
+		}
+		return validationFunc(token)
+	}
+}
+
+// validationFunc returns the function used to retrieve the token validation key: the
+// specification ValidationFunc or the keyring Keyfunc method if there is no ValidationFunc.
+func (spec *Specification) validationFunc() ValidationKeyfunc {
+	if spec.ValidationFunc == nil && spec.Keyring != nil {
+		return spec.Keyring.Keyfunc
+	}
+	return spec.ValidationFunc
+}
+
+// validateClaims validates the standard claims against the specification.
+func (spec *Specification) validateClaims(claims map[string]interface{}, now time.Time) error {
+	skew := spec.ClockSkew
+	exp, ok, err := timeClaim(claims, "exp")
+	if err != nil {
+		return err
+	}
+	if ok && !now.Before(exp.Add(skew)) {
+		return &ClaimError{"exp", "token is expired"}
+	}
+	nbf, ok, err := timeClaim(claims, "nbf")
+	if err != nil {
+		return err
+	}
+	if ok && now.Add(skew).Before(nbf) {
+		return &ClaimError{"nbf", "token is not valid yet"}
+	}
+	iat, hasIat, err := timeClaim(claims, "iat")
+	if err != nil {
+		return err
+	}
+	if hasIat && now.Add(skew).Before(iat) {
+		return &ClaimError{"iat", "token is issued in the future"}
+	}
+	if spec.MaxTokenAge > 0 {
+		if !hasIat {
+			return &ClaimError{"iat", "token has no issued at time"}
+		}
+		if now.Sub(iat) > spec.MaxTokenAge+skew {
+			return &ClaimError{"iat", "token is too old"}
+		}
+	}
+	if len(spec.ValidIssuers) > 0 {
+		iss, _ := claims["iss"].(string)
+		if !contains(spec.ValidIssuers, iss) {
+			return &ClaimError{"iss", fmt.Sprintf("token issuer %q is not accepted", iss)}
+		}
+	}
+	if len(spec.ValidAudiences) > 0 {
+		valid := false
+		for _, aud := range audiences(claims) {
+			if contains(spec.ValidAudiences, aud) {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return &ClaimError{"aud", "token audience is not accepted"}
+		}
+	}
+	for _, name := range spec.RequiredClaims {
+		if _, ok := claims[name]; !ok {
+			return &ClaimError{name, fmt.Sprintf("token is missing required claim %q", name)}
+		}
+	}
+	return nil
+}
+
+// timeClaim returns the time stored in the given NumericDate claim and whether the claim is
+// present.
+func timeClaim(claims map[string]interface{}, name string) (time.Time, bool, error) {
+	v, ok := claims[name]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	f, ok := v.(float64)
+	if !ok {
+		return time.Time{}, false, &ClaimError{name, fmt.Sprintf("token claim %q is not a number", name)}
+	}
+	return time.Unix(int64(f), 0), true, nil
+}
+
+// audiences returns the values of the "aud" claim which may be a string or an array of strings.
+func audiences(claims map[string]interface{}) []string {
+	switch aud := claims["aud"].(type) {
+	case string:
+		return []string{aud}
+	case []interface{}:
+		res := make([]string, 0, len(aud))
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				res = append(res, s)
+			}
+		}
+		return res
+	}
+	return nil
+}
+
+// contains returns true if vals contains val.
+func contains(vals []string, val string) bool {
+	for _, v := range vals {
+		if v == val {
+			return true
+		}
+	}
+	return false
+}
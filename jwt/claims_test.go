@@ -0,0 +1,133 @@
+package jwt_test
+
+import (
+	"net/http"
+	"time"
+
+	jwtg "github.com/dgrijalva/jwt-go"
+	"github.com/goadesign/middleware/jwt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Claim validation", func() {
+	var spec *jwt.Specification
+	var claims map[string]interface{}
+	var rw *TestResponseWriter
+	var status int
+
+	JustBeforeEach(func() {
+		token := jwtg.New(jwtg.SigningMethodHS256)
+		for k, v := range claims {
+			token.Claims[k] = v
+		}
+		tok, err := token.SignedString(signingKey)
+		Ω(err).ShouldNot(HaveOccurred())
+		res := serve(jwt.Middleware(spec), tok)
+		Ω(res.Err).ShouldNot(HaveOccurred())
+		rw = res.Writer
+		status = res.Status
+	})
+
+	BeforeEach(func() {
+		spec = &jwt.Specification{
+			ValidationFunc: func(*jwtg.Token) (interface{}, error) { return signingKey, nil },
+			ValidIssuers:   []string{"goa"},
+			ValidAudiences: []string{"api"},
+			RequiredClaims: []string{"sub"},
+		}
+		claims = map[string]interface{}{
+			"iss": "goa",
+			"aud": []string{"web", "api"},
+			"sub": "alice",
+			"iat": time.Now().Unix(),
+			"exp": time.Now().Add(time.Hour).Unix(),
+		}
+	})
+
+	It("accepts valid tokens", func() {
+		Ω(status).Should(Equal(http.StatusOK))
+	})
+
+	Context("with an unexpected issuer", func() {
+		BeforeEach(func() { claims["iss"] = "evil" })
+
+		It("rejects the token", func() {
+			Ω(status).Should(Equal(http.StatusUnauthorized))
+			Ω(string(rw.Body)).Should(ContainSubstring("issuer"))
+		})
+	})
+
+	Context("with an unexpected audience", func() {
+		BeforeEach(func() { claims["aud"] = "other" })
+
+		It("rejects the token", func() {
+			Ω(status).Should(Equal(http.StatusUnauthorized))
+			Ω(string(rw.Body)).Should(ContainSubstring("audience"))
+		})
+	})
+
+	Context("with a missing required claim", func() {
+		BeforeEach(func() { delete(claims, "sub") })
+
+		It("rejects the token", func() {
+			Ω(status).Should(Equal(http.StatusUnauthorized))
+			Ω(string(rw.Body)).Should(ContainSubstring(`missing required claim \"sub\"`))
+		})
+	})
+
+	Context("with a recently expired token", func() {
+		BeforeEach(func() { claims["exp"] = time.Now().Add(-10 * time.Second).Unix() })
+
+		It("rejects the token", func() {
+			Ω(status).Should(Equal(http.StatusUnauthorized))
+			Ω(string(rw.Body)).Should(ContainSubstring("expired"))
+		})
+
+		Context("and clock skew", func() {
+			BeforeEach(func() { spec.ClockSkew = time.Minute })
+
+			It("accepts the token", func() {
+				Ω(status).Should(Equal(http.StatusOK))
+			})
+		})
+	})
+
+	Context("with a token not valid yet", func() {
+		BeforeEach(func() { claims["nbf"] = time.Now().Add(time.Hour).Unix() })
+
+		It("rejects the token", func() {
+			Ω(status).Should(Equal(http.StatusUnauthorized))
+			Ω(string(rw.Body)).Should(ContainSubstring("not valid yet"))
+		})
+	})
+
+	Context("with a token issued in the future", func() {
+		BeforeEach(func() { claims["iat"] = time.Now().Add(10 * time.Second).Unix() })
+
+		It("rejects the token", func() {
+			Ω(status).Should(Equal(http.StatusUnauthorized))
+			Ω(string(rw.Body)).Should(ContainSubstring("issued in the future"))
+		})
+
+		Context("and clock skew", func() {
+			BeforeEach(func() { spec.ClockSkew = time.Minute })
+
+			It("accepts the token", func() {
+				Ω(status).Should(Equal(http.StatusOK))
+			})
+		})
+	})
+
+	Context("with a max token age", func() {
+		BeforeEach(func() {
+			spec.MaxTokenAge = time.Minute
+			claims["iat"] = time.Now().Add(-time.Hour).Unix()
+		})
+
+		It("rejects older tokens", func() {
+			Ω(status).Should(Equal(http.StatusUnauthorized))
+			Ω(string(rw.Body)).Should(ContainSubstring("too old"))
+		})
+	})
+})
@@ -0,0 +1,159 @@
+package jwt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+
+	"golang.org/x/net/context"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// ErrNoToken is the error returned by the context helpers when the context does not contain a
+// token, typically because Middleware is not mounted.
+var ErrNoToken = errors.New("no token in context")
+
+// ContextToken returns the token stored in the context by Middleware.
+func ContextToken(ctx context.Context) (*jwt.Token, error) {
+	token, ok := ctx.Value(JWTKey).(*jwt.Token)
+	if !ok || token == nil {
+		return nil, ErrNoToken
+	}
+	return token, nil
+}
+
+// Claim returns the value of the claim with the given name of the token stored in the context.
+func Claim(ctx context.Context, name string) (interface{}, error) {
+	token, err := ContextToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	v, ok := token.Claims[name]
+	if !ok {
+		return nil, &ClaimError{name, fmt.Sprintf("token is missing claim %q", name)}
+	}
+	return v, nil
+}
+
+// ClaimString returns the value of the string claim with the given name of the token stored in
+// the context.
+func ClaimString(ctx context.Context, name string) (string, error) {
+	v, err := Claim(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", claimTypeError(name, v, "a string")
+	}
+	return s, nil
+}
+
+// ClaimInt returns the value of the integer claim with the given name of the token stored in
+// the context.
+func ClaimInt(ctx context.Context, name string) (int64, error) {
+	v, err := Claim(ctx, name)
+	if err != nil {
+		return 0, err
+	}
+	f, ok := v.(float64)
+	if !ok || f != math.Trunc(f) {
+		return 0, claimTypeError(name, v, "an integer")
+	}
+	return int64(f), nil
+}
+
+// ClaimFloat returns the value of the numeric claim with the given name of the token stored in
+// the context.
+func ClaimFloat(ctx context.Context, name string) (float64, error) {
+	v, err := Claim(ctx, name)
+	if err != nil {
+		return 0, err
+	}
+	f, ok := v.(float64)
+	if !ok {
+		return 0, claimTypeError(name, v, "a number")
+	}
+	return f, nil
+}
+
+// ClaimBool returns the value of the boolean claim with the given name of the token stored in
+// the context.
+func ClaimBool(ctx context.Context, name string) (bool, error) {
+	v, err := Claim(ctx, name)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, claimTypeError(name, v, "a boolean")
+	}
+	return b, nil
+}
+
+// ClaimStrings returns the value of the string array claim with the given name of the token
+// stored in the context. A claim containing a single string is returned as a one element slice.
+func ClaimStrings(ctx context.Context, name string) ([]string, error) {
+	v, err := Claim(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	switch c := v.(type) {
+	case string:
+		return []string{c}, nil
+	case []interface{}:
+		res := make([]string, len(c))
+		for i, e := range c {
+			s, ok := e.(string)
+			if !ok {
+				return nil, claimTypeError(name, v, "an array of strings")
+			}
+			res[i] = s
+		}
+		return res, nil
+	}
+	return nil, claimTypeError(name, v, "an array of strings")
+}
+
+// Subject returns the "sub" claim of the token stored in the context.
+func Subject(ctx context.Context) (string, error) {
+	return ClaimString(ctx, "sub")
+}
+
+// Scopes returns the scopes listed in the "scope" or "scopes" claim of the token stored in the
+// context.
+func Scopes(ctx context.Context) ([]string, error) {
+	token, err := ContextToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return tokenScopes(token, ""), nil
+}
+
+// DecodeClaims decodes the claims of the token stored in the context into v which must be a
+// pointer. The claims are decoded using the encoding/json package so that the v struct fields
+// may use json tags to map claim names.
+func DecodeClaims(ctx context.Context, v interface{}) error {
+	token, err := ContextToken(ctx)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(token.Claims)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		if te, ok := err.(*json.UnmarshalTypeError); ok {
+			return &ClaimError{"", fmt.Sprintf("token claim cannot be decoded: %s", te)}
+		}
+		return err
+	}
+	return nil
+}
+
+// claimTypeError returns the error reported when a claim does not have the expected type.
+func claimTypeError(name string, v interface{}, expected string) error {
+	return &ClaimError{name, fmt.Sprintf("token claim %q is not %s (%T)", name, expected, v)}
+}
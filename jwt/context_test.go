@@ -0,0 +1,78 @@
+package jwt_test
+
+import (
+	"golang.org/x/net/context"
+
+	jwtg "github.com/dgrijalva/jwt-go"
+	"github.com/goadesign/middleware/jwt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Context helpers", func() {
+	var ctx context.Context
+	var token *jwtg.Token
+
+	BeforeEach(func() {
+		token = jwtg.New(jwtg.SigningMethodHS256)
+		token.Claims["sub"] = "alice"
+		token.Claims["age"] = float64(42)
+		token.Claims["admin"] = true
+		token.Claims["groups"] = []interface{}{"dev", "ops"}
+		token.Claims["scope"] = "read write"
+		ctx = context.WithValue(context.Background(), jwt.JWTKey, token)
+	})
+
+	It("returns the token", func() {
+		t, err := jwt.ContextToken(ctx)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(t).Should(Equal(token))
+	})
+
+	It("reports missing tokens", func() {
+		_, err := jwt.Subject(context.Background())
+		Ω(err).Should(Equal(jwt.ErrNoToken))
+	})
+
+	It("returns typed claims", func() {
+		Ω(jwt.Subject(ctx)).Should(Equal("alice"))
+		Ω(jwt.ClaimInt(ctx, "age")).Should(Equal(int64(42)))
+		Ω(jwt.ClaimFloat(ctx, "age")).Should(Equal(float64(42)))
+		Ω(jwt.ClaimBool(ctx, "admin")).Should(BeTrue())
+		Ω(jwt.ClaimStrings(ctx, "groups")).Should(Equal([]string{"dev", "ops"}))
+		Ω(jwt.Scopes(ctx)).Should(Equal([]string{"read", "write"}))
+	})
+
+	It("reports missing claims", func() {
+		_, err := jwt.ClaimString(ctx, "email")
+		Ω(err).Should(HaveOccurred())
+		Ω(err.(*jwt.ClaimError).Claim).Should(Equal("email"))
+	})
+
+	It("reports mistyped claims", func() {
+		_, err := jwt.ClaimString(ctx, "age")
+		Ω(err).Should(HaveOccurred())
+		Ω(err.Error()).Should(ContainSubstring("not a string"))
+	})
+
+	It("decodes claims into structs", func() {
+		var claims struct {
+			Subject string   `json:"sub"`
+			Age     int      `json:"age"`
+			Groups  []string `json:"groups"`
+		}
+		Ω(jwt.DecodeClaims(ctx, &claims)).ShouldNot(HaveOccurred())
+		Ω(claims.Subject).Should(Equal("alice"))
+		Ω(claims.Age).Should(Equal(42))
+		Ω(claims.Groups).Should(Equal([]string{"dev", "ops"}))
+	})
+
+	It("reports claims that cannot be decoded", func() {
+		var claims struct {
+			Subject int `json:"sub"`
+		}
+		err := jwt.DecodeClaims(ctx, &claims)
+		Ω(err).Should(HaveOccurred())
+		Ω(err).Should(BeAssignableToTypeOf(&jwt.ClaimError{}))
+	})
+})
@@ -20,6 +20,83 @@ algorithms.
 	}
 	authorizedController.Use(jwt.Middleware(spec))
 
+Besides the token signature the middleware validates the "exp", "nbf" and "iat" claims allowing
+for the specification ClockSkew. The specification may also list the accepted issuers and
+audiences, the claims tokens must have and the maximum token age:
+
+	spec.ValidIssuers = []string{"me.com"}
+	spec.ValidAudiences = []string{"api.me.com"}
+	spec.RequiredClaims = []string{"sub"}
+	spec.ClockSkew = 30 * time.Second
+	spec.MaxTokenAge = 24 * time.Hour
+
+The supported signing methods are HMAC (HS256, HS384, HS512), RSA (RS256, RS384, RS512), RSA-PSS
+(PS256, PS384, PS512), ECDSA (ES256, ES384, ES512) and EdDSA with Ed25519 keys. When
+KeySigningMethod is set the middleware rejects tokens signed with any other algorithm, this
+prevents algorithm confusion attacks where a token is for example signed with HMAC using the RSA
+public key as secret.
+
+Requests without a valid token are rejected with a WWW-Authenticate header as defined by RFC
+6750, e.g. `Bearer realm="api", error="invalid_token", error_description="token is expired"`. The
+realm is set with the specification Realm field. The response body is written by the
+specification ErrorResponder which may be overridden to render custom error media types.
+
+Token Sources
+
+By default the middleware reads the token from the Authorization header and, if AllowParam is
+true, from the "token" querystring parameter. The specification Extractors field overrides this
+behavior with an ordered list of token sources. Tokens read from cookies may be protected
+against CSRF attacks with the double submit cookie pattern:
+
+	spec.Extractors = []jwt.TokenExtractor{
+		jwt.HeaderExtractor("Authorization", "Bearer"),
+		jwt.CookieExtractor("session", &jwt.CSRF{}), // Requires the X-CSRF-Token header to match the csrf_token cookie
+	}
+
+Accessing Claims
+
+Controllers retrieve the token stored in the context by the middleware with ContextToken and its
+claims with the typed helpers Subject, ClaimString, ClaimInt, ClaimFloat, ClaimBool and
+ClaimStrings. Missing or mistyped claims are reported with a *ClaimError. DecodeClaims decodes
+all the claims into a struct:
+
+	var claims struct {
+		AccountID string `json:"accountID"`
+	}
+	if err := jwt.DecodeClaims(ctx, &claims); err != nil {
+		return err
+	}
+
+Scopes
+
+The RequireScopes and RequireAnyScope middlewares check the scopes of the token stored in the
+context by the JWT middleware and respond with 403 if the token lacks the required scopes. The
+scopes are read from the "scope" claim (space separated string) or the "scopes" claim (array):
+
+	adminController.Use(jwt.Middleware(spec))
+	adminController.Use(jwt.RequireScopes("admin"))
+
+JSON Web Key Sets
+
+Tokens issued by identity providers that publish their keys in a JSON Web Key Set may be validated
+using the Keyfunc method of a JWKS. The key set is retrieved and cached, keys are selected using
+the token "kid" header. Keys that cannot be used, for example keys with an unsupported type, are
+skipped and reported to the JWKS OnInvalidKey function:
+
+	jwks := jwt.NewJWKS("https://idp.me.com/.well-known/jwks.json")
+	spec.ValidationFunc = jwks.Keyfunc
+
+Token Introspection
+
+Opaque access tokens are validated with IntrospectionMiddleware which posts the token to an OAuth2
+introspection endpoint (RFC 7662) and caches the result until the token expires. The claims of
+active tokens are stored in the context the same way as with Middleware so that the scope
+middlewares and claim helpers work with both:
+
+	intro := jwt.NewIntrospection("https://idp.me.com/introspect", clientID, clientSecret)
+	controller.Use(jwt.IntrospectionMiddleware(intro))
+	controller.Use(jwt.RequireScopes("read"))
+
 Token Manager
 
 The package also exposes a token manager that creates the JWT tokens. The manager is instantiated
@@ -41,5 +118,59 @@ using the same specification used to create the middleware:
 		return ctx.Respond(200, token) // You'll probably need something different here
 	}
 
+Encrypted Tokens
+
+Tokens may carry claims that should not be readable by clients. When the specification
+EncryptionKeyFunc field is set the token manager wraps the signed tokens into JWE tokens using the
+KeyEncryptionMethod key management algorithm (RSA-OAEP, RSA-OAEP-256 or dir) and A256GCM content
+encryption. The middleware decrypts these tokens with the key returned by DecryptionKeyFunc before
+validating them:
+
+	spec.KeyEncryptionMethod = jwt.RSAOAEP
+	spec.EncryptionKeyFunc = func() (interface{}, error) { return &privKey.PublicKey, nil }
+	spec.DecryptionKeyFunc = func() (interface{}, error) { return privKey, nil }
+
+Tokens that cannot be decrypted are rejected with an "invalid_token" error, so are tokens that are
+not encrypted once DecryptionKeyFunc is set. Failures to retrieve
+the decryption key are server side errors: the middleware returns a KeyError so that goa responds
+with an internal error.
+
+Key Rotation
+
+A Keyring holds several keys with activation and retirement times. When the specification
+Keyring field is set the token manager signs tokens with the most recently activated key and
+stamps its ID in the "kid" header, the middleware accepts tokens signed with any non retired key
+of the keyring. The public keys may be published as a JSON Web Key Set:
+
+	keyring := jwt.NewKeyring(
+		&jwt.Key{ID: "2016-01", Method: jwt.RSA256, SigningKey: oldKey, RetiresAt: retirement},
+		&jwt.Key{ID: "2016-02", Method: jwt.RSA256, SigningKey: newKey, ActivatesAt: activation},
+	)
+	spec.Keyring = keyring
+	http.Handle("/.well-known/jwks.json", keyring.JWKSHandler())
+
+Refresh Tokens
+
+CreatePair creates an access token together with a refresh token valid for RefreshTTLMinutes.
+Refresh tokens carry a "typ" claim set to "refresh" and are rejected by the middleware. Refresh
+exchanges a refresh token for a new pair:
+
+	pair, err := tm.Refresh(refreshToken)
+
+Setting the specification RotateRefreshTokens field makes refresh tokens single use: each
+exchange returns a new refresh token and presenting an already exchanged refresh token revokes
+all the refresh tokens derived from it. The issued refresh tokens are tracked by the
+specification RefreshStore which defaults to an in-memory store.
+
+Revocation
+
+The token manager sets a unique "jti" claim on all the tokens it creates. If the specification
+RevocationStore field is set then tokens may be revoked before they expire, the middleware
+rejects revoked tokens with a 401 response:
+
+	spec.RevocationStore = jwt.NewMemoryRevocationStore()
+	// ...
+	err := tm.Revoke(token)
+
 */
 package jwt
@@ -0,0 +1,51 @@
+package jwt
+
+import (
+	"errors"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"golang.org/x/crypto/ed25519"
+)
+
+// SigningMethodEdDSA implements the EdDSA signing method using Ed25519 keys as defined by RFC
+// 8037. It expects an ed25519.PrivateKey to sign and an ed25519.PublicKey to validate.
+type SigningMethodEdDSA struct{}
+
+// errEdDSAVerification is the error returned when an EdDSA signature does not verify.
+var errEdDSAVerification = errors.New("ed25519: verification error")
+
+func init() {
+	jwt.RegisterSigningMethod("EdDSA", func() jwt.SigningMethod {
+		return &SigningMethodEdDSA{}
+	})
+}
+
+// Alg returns the JWS algorithm name.
+func (m *SigningMethodEdDSA) Alg() string {
+	return "EdDSA"
+}
+
+// Verify checks the signature of signingString using the ed25519.PublicKey key.
+func (m *SigningMethodEdDSA) Verify(signingString, signature string, key interface{}) error {
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok || len(pub) != ed25519.PublicKeySize {
+		return jwt.ErrInvalidKey
+	}
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(pub, []byte(signingString), sig) {
+		return errEdDSAVerification
+	}
+	return nil
+}
+
+// Sign signs signingString using the ed25519.PrivateKey key.
+func (m *SigningMethodEdDSA) Sign(signingString string, key interface{}) (string, error) {
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok || len(priv) != ed25519.PrivateKeySize {
+		return "", jwt.ErrInvalidKey
+	}
+	return jwt.EncodeSegment(ed25519.Sign(priv, []byte(signingString))), nil
+}
@@ -0,0 +1,115 @@
+package jwt_test
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	"golang.org/x/crypto/ed25519"
+
+	jwtg "github.com/dgrijalva/jwt-go"
+	"github.com/goadesign/middleware/jwt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Signing methods", func() {
+	alg := func(token string) interface{} {
+		t, _ := jwtg.Parse(token, nil)
+		return t.Header["alg"]
+	}
+
+	Context("with EdDSA", func() {
+		var pub ed25519.PublicKey
+		var spec *jwt.Specification
+
+		BeforeEach(func() {
+			var priv ed25519.PrivateKey
+			var err error
+			pub, priv, err = ed25519.GenerateKey(rand.Reader)
+			Ω(err).ShouldNot(HaveOccurred())
+			spec = &jwt.Specification{
+				KeySigningMethod: jwt.EdDSA,
+				SigningKeyFunc:   func() (interface{}, error) { return priv, nil },
+				ValidationFunc:   func(*jwtg.Token) (interface{}, error) { return pub, nil },
+			}
+		})
+
+		It("signs and validates tokens", func() {
+			token, err := jwt.NewTokenManager(spec).Create(map[string]interface{}{"sub": "alice"})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(alg(token)).Should(Equal("EdDSA"))
+			res := serve(jwt.Middleware(spec), token)
+			Ω(res.Err).ShouldNot(HaveOccurred())
+			Ω(res.Status).Should(Equal(http.StatusOK))
+		})
+
+		It("rejects tokens signed with another key", func() {
+			_, other, err := ed25519.GenerateKey(rand.Reader)
+			Ω(err).ShouldNot(HaveOccurred())
+			spec.SigningKeyFunc = func() (interface{}, error) { return other, nil }
+			token, err := jwt.NewTokenManager(spec).Create(map[string]interface{}{"sub": "alice"})
+			Ω(err).ShouldNot(HaveOccurred())
+			res := serve(jwt.Middleware(spec), token)
+			Ω(res.Err).ShouldNot(HaveOccurred())
+			Ω(res.Status).Should(Equal(http.StatusUnauthorized))
+		})
+
+		It("publishes OKP keys in the keyring JWKS", func() {
+			_, priv, err := ed25519.GenerateKey(rand.Reader)
+			Ω(err).ShouldNot(HaveOccurred())
+			keyring := jwt.NewKeyring(&jwt.Key{ID: "ed", Method: jwt.EdDSA, SigningKey: priv})
+			server := httptest.NewServer(keyring.JWKSHandler())
+			defer server.Close()
+			rw := httptest.NewRecorder()
+			keyring.JWKSHandler().ServeHTTP(rw, nil)
+			var set struct {
+				Keys []map[string]string `json:"keys"`
+			}
+			Ω(json.Unmarshal(rw.Body.Bytes(), &set)).ShouldNot(HaveOccurred())
+			Ω(set.Keys).Should(HaveLen(1))
+			Ω(set.Keys[0]["kty"]).Should(Equal("OKP"))
+			Ω(set.Keys[0]["crv"]).Should(Equal("Ed25519"))
+
+			token, err := jwt.NewTokenManager(&jwt.Specification{Keyring: keyring}).Create(map[string]interface{}{"sub": "alice"})
+			Ω(err).ShouldNot(HaveOccurred())
+			_, err = jwtg.Parse(token, jwtg.Keyfunc(jwt.NewJWKS(server.URL).Keyfunc))
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+	})
+
+	Context("with RSA-PSS", func() {
+		It("signs and validates tokens", func() {
+			key, err := jwtg.ParseRSAPrivateKeyFromPEM(rsaSampleKey)
+			Ω(err).ShouldNot(HaveOccurred())
+			spec := &jwt.Specification{
+				KeySigningMethod: jwt.RSAPSS256,
+				SigningKeyFunc:   func() (interface{}, error) { return key, nil },
+				ValidationFunc:   func(*jwtg.Token) (interface{}, error) { return &key.PublicKey, nil },
+			}
+			token, err := jwt.NewTokenManager(spec).Create(map[string]interface{}{"sub": "alice"})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(alg(token)).Should(Equal("PS256"))
+			res := serve(jwt.Middleware(spec), token)
+			Ω(res.Err).ShouldNot(HaveOccurred())
+			Ω(res.Status).Should(Equal(http.StatusOK))
+		})
+	})
+
+	Context("with a token using another algorithm", func() {
+		It("rejects HMAC tokens signed with the RSA public key", func() {
+			spec := &jwt.Specification{
+				KeySigningMethod: jwt.RSA256,
+				ValidationFunc:   func(*jwtg.Token) (interface{}, error) { return rsaSampleKeyPub, nil },
+			}
+			forged := jwtg.New(jwtg.SigningMethodHS256)
+			forged.Claims["sub"] = "mallory"
+			token, err := forged.SignedString(rsaSampleKeyPub)
+			Ω(err).ShouldNot(HaveOccurred())
+			res := serve(jwt.Middleware(spec), token)
+			Ω(res.Err).ShouldNot(HaveOccurred())
+			Ω(res.Status).Should(Equal(http.StatusUnauthorized))
+		})
+	})
+})
@@ -0,0 +1,155 @@
+package jwt
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/goadesign/goa"
+)
+
+// Error codes defined by RFC 6750 section 3.1.
+const (
+	// ErrorCodeInvalidRequest indicates a malformed request.
+	ErrorCodeInvalidRequest = "invalid_request"
+	// ErrorCodeInvalidToken indicates an expired, revoked, malformed or otherwise invalid token.
+	ErrorCodeInvalidToken = "invalid_token"
+	// ErrorCodeInsufficientScope indicates a token lacking the scopes required by the request.
+	ErrorCodeInsufficientScope = "insufficient_scope"
+)
+
+var (
+	// ErrTokenNotFound is the error returned by GetToken when the request carries no token.
+	ErrTokenNotFound = errors.New("no token")
+	// ErrMalformedHeader is the error returned by header extractors when the header value
+	// does not use the expected authentication scheme.
+	ErrMalformedHeader = errors.New("malformed token header")
+	// ErrTokenDecryption is the error returned by GetToken when an encrypted token cannot be
+	// decrypted.
+	ErrTokenDecryption = errors.New("token could not be decrypted")
+	// ErrTokenNotEncrypted is the error returned by GetToken when the specification
+	// DecryptionKeyFunc is set and the token is not encrypted.
+	ErrTokenNotEncrypted = errors.New("token is not encrypted")
+)
+
+type (
+	// AuthError describes an authentication or authorization failure. The middlewares of this
+	// package use it to build the WWW-Authenticate header and the response body.
+	AuthError struct {
+		// Status is the HTTP response status code.
+		Status int
+		// Code is the RFC 6750 error code, empty if the request carries no token.
+		Code string
+		// Description is the human readable error description.
+		Description string
+		// Scope lists the scopes required by the request for "insufficient_scope" errors.
+		Scope string
+	}
+
+	// KeyError is the error returned by GetToken when the key needed to decrypt an encrypted
+	// token cannot be retrieved. It denotes a server side failure rather than an invalid token,
+	// the middlewares return it instead of writing an error response.
+	KeyError struct {
+		// Err is the error returned by the key function.
+		Err error
+	}
+
+	// ErrorResponder is the function invoked by the middlewares to write the error response
+	// body. The WWW-Authenticate header is already set when it is called. Custom responders
+	// may for example render the goa error media types.
+	ErrorResponder func(ctx context.Context, rw http.ResponseWriter, req *http.Request, e *AuthError) error
+)
+
+// Error returns the error description.
+func (e *AuthError) Error() string {
+	if e.Description == "" {
+		return http.StatusText(e.Status)
+	}
+	return e.Description
+}
+
+// Error returns the error message.
+func (e *KeyError) Error() string {
+	return fmt.Sprintf("failed to retrieve decryption key: %s", e.Err)
+}
+
+// Challenge returns the value of the WWW-Authenticate header for the error as defined by RFC 6750
+// section 3.
+func (e *AuthError) Challenge(realm string) string {
+	var params []string
+	if realm != "" {
+		params = append(params, fmt.Sprintf(`realm="%s"`, quote(realm)))
+	}
+	if e.Code != "" {
+		params = append(params, fmt.Sprintf(`error="%s"`, e.Code))
+		if e.Description != "" {
+			params = append(params, fmt.Sprintf(`error_description="%s"`, quote(e.Description)))
+		}
+	}
+	if e.Scope != "" {
+		params = append(params, fmt.Sprintf(`scope="%s"`, quote(e.Scope)))
+	}
+	if len(params) == 0 {
+		return "Bearer"
+	}
+	return "Bearer " + strings.Join(params, ", ")
+}
+
+// DefaultErrorResponder sends the error description, or the status text if there is none, as
+// response body.
+func DefaultErrorResponder(ctx context.Context, rw http.ResponseWriter, req *http.Request, e *AuthError) error {
+	return goa.Response(ctx).Send(ctx, e.Status, e.Error())
+}
+
+// respond sets the WWW-Authenticate header and writes the error response using the given
+// responder or DefaultErrorResponder if nil.
+func respond(ctx context.Context, rw http.ResponseWriter, req *http.Request, e *AuthError, realm string, responder ErrorResponder) error {
+	goa.Response(ctx).Header().Set("WWW-Authenticate", e.Challenge(realm))
+	if responder == nil {
+		responder = DefaultErrorResponder
+	}
+	return responder(ctx, rw, req, e)
+}
+
+// tokenError returns the AuthError corresponding to an error returned by GetToken.
+func tokenError(err error) *AuthError {
+	switch err {
+	case ErrTokenNotFound:
+		return &AuthError{Status: http.StatusUnauthorized}
+	case ErrCSRFMismatch:
+		return &AuthError{Status: http.StatusForbidden, Code: ErrorCodeInvalidRequest, Description: err.Error()}
+	case ErrTokenDecryption, ErrTokenNotEncrypted:
+		return invalidToken(err.Error())
+	}
+	switch e := err.(type) {
+	case *ClaimError:
+		return invalidToken(e.Error())
+	case *jwt.ValidationError:
+		switch {
+		case e.Errors&jwt.ValidationErrorMalformed != 0:
+			return invalidToken("token is malformed")
+		case e.Errors&jwt.ValidationErrorSignatureInvalid != 0:
+			return invalidToken("token signature is invalid")
+		case e.Errors&jwt.ValidationErrorUnverifiable != 0:
+			return invalidToken("token could not be verified")
+		}
+		return invalidToken("token is invalid")
+	}
+	// Errors returned by extractors
+	return &AuthError{Status: http.StatusBadRequest, Code: ErrorCodeInvalidRequest, Description: err.Error()}
+}
+
+// invalidToken returns an "invalid_token" error with the given description.
+func invalidToken(description string) *AuthError {
+	return &AuthError{Status: http.StatusUnauthorized, Code: ErrorCodeInvalidToken, Description: description}
+}
+
+// quote escapes the double quote and backslash characters so that s may be used in a quoted
+// string.
+func quote(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s)
+}
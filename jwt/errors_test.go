@@ -0,0 +1,97 @@
+package jwt_test
+
+import (
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+
+	jwtg "github.com/dgrijalva/jwt-go"
+	"github.com/goadesign/goa"
+	"github.com/goadesign/middleware/jwt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Error responses", func() {
+	var spec *jwt.Specification
+	var authorization string
+	var rw *TestResponseWriter
+	var ctx context.Context
+
+	JustBeforeEach(func() {
+		var req *http.Request
+		ctx, rw, req = newContext("")
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		h := func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+			panic("unreachable")
+		}
+		Ω(jwt.Middleware(spec)(h)(ctx, rw, req)).ShouldNot(HaveOccurred())
+	})
+
+	BeforeEach(func() {
+		authorization = ""
+		spec = &jwt.Specification{
+			Realm:          "api",
+			ValidationFunc: func(*jwtg.Token) (interface{}, error) { return signingKey, nil },
+		}
+	})
+
+	Context("without token", func() {
+		It("sends a challenge without error code", func() {
+			Ω(goa.Response(ctx).Status).Should(Equal(http.StatusUnauthorized))
+			Ω(rw.Header().Get("WWW-Authenticate")).Should(Equal(`Bearer realm="api"`))
+		})
+	})
+
+	Context("with a malformed header", func() {
+		BeforeEach(func() { authorization = "Basic Zm9vOmJhcg==" })
+
+		It("reports an invalid request", func() {
+			Ω(goa.Response(ctx).Status).Should(Equal(http.StatusBadRequest))
+			Ω(rw.Header().Get("WWW-Authenticate")).Should(Equal(
+				`Bearer realm="api", error="invalid_request", error_description="malformed token header"`))
+		})
+	})
+
+	Context("with an expired token", func() {
+		BeforeEach(func() {
+			token := jwtg.New(jwtg.SigningMethodHS256)
+			token.Claims["exp"] = time.Now().Add(-time.Hour).Unix()
+			tok, err := token.SignedString(signingKey)
+			Ω(err).ShouldNot(HaveOccurred())
+			authorization = "Bearer " + tok
+		})
+
+		It("reports an invalid token", func() {
+			Ω(goa.Response(ctx).Status).Should(Equal(http.StatusUnauthorized))
+			Ω(rw.Header().Get("WWW-Authenticate")).Should(Equal(
+				`Bearer realm="api", error="invalid_token", error_description="token is expired"`))
+		})
+	})
+
+	Context("with a malformed token", func() {
+		BeforeEach(func() { authorization = "Bearer foo" })
+
+		It("reports an invalid token", func() {
+			Ω(goa.Response(ctx).Status).Should(Equal(http.StatusUnauthorized))
+			Ω(rw.Header().Get("WWW-Authenticate")).Should(ContainSubstring(`error="invalid_token"`))
+		})
+	})
+
+	Context("with a custom responder", func() {
+		BeforeEach(func() {
+			spec.ErrorResponder = func(ctx context.Context, rw http.ResponseWriter, req *http.Request, e *jwt.AuthError) error {
+				return goa.Response(ctx).Send(ctx, e.Status, map[string]string{"code": e.Code, "detail": e.Error()})
+			}
+			authorization = "Bearer foo"
+		})
+
+		It("uses it to render the body", func() {
+			Ω(goa.Response(ctx).Status).Should(Equal(http.StatusUnauthorized))
+			Ω(string(rw.Body)).Should(ContainSubstring(`"code":"invalid_token"`))
+		})
+	})
+})
@@ -0,0 +1,125 @@
+package jwt
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// TokenExtractor is a function that extracts the raw token from a request. It returns an empty
+// string if the request does not carry a token in the location handled by the extractor.
+type TokenExtractor func(req *http.Request) (string, error)
+
+// CSRF describes the double submit cookie protection applied to tokens read from cookies: the
+// value of the CSRF cookie must be repeated in the CSRF header of requests with unsafe methods.
+type CSRF struct {
+	// CookieName is the name of the cookie holding the CSRF token
+	// Defaults to "csrf_token"
+	CookieName string
+	// HeaderName is the name of the header that must repeat the CSRF token
+	// Defaults to "X-CSRF-Token"
+	HeaderName string
+}
+
+// ErrCSRFMismatch is the error returned by cookie extractors when the CSRF double submit check
+// fails.
+var ErrCSRFMismatch = errors.New("CSRF token mismatch")
+
+// HeaderExtractor returns a TokenExtractor that reads the token from the header with the given
+// name. If scheme is not empty then the header value must consist of the scheme followed by a
+// space and the token, e.g. "Bearer <token>", the scheme is compared case insensitively.
+func HeaderExtractor(name, scheme string) TokenExtractor {
+	return func(req *http.Request) (string, error) {
+		header := req.Header.Get(name)
+		if header == "" || scheme == "" {
+			return header, nil
+		}
+		parts := strings.Split(header, " ")
+		if len(parts) != 2 || !strings.EqualFold(parts[0], scheme) {
+			return "", ErrMalformedHeader
+		}
+		return parts[1], nil
+	}
+}
+
+// QueryExtractor returns a TokenExtractor that reads the token from the querystring parameter
+// with the given name.
+func QueryExtractor(param string) TokenExtractor {
+	return func(req *http.Request) (string, error) {
+		return req.URL.Query().Get(param), nil
+	}
+}
+
+// FormExtractor returns a TokenExtractor that reads the token from the form field with the
+// given name of URL encoded or multipart request bodies.
+func FormExtractor(field string) TokenExtractor {
+	return func(req *http.Request) (string, error) {
+		return req.PostFormValue(field), nil
+	}
+}
+
+// CookieExtractor returns a TokenExtractor that reads the token from the cookie with the given
+// name. If csrf is not nil then requests with methods other than GET, HEAD, OPTIONS and TRACE
+// must pass the CSRF double submit check for the token to be extracted.
+func CookieExtractor(name string, csrf *CSRF) TokenExtractor {
+	return func(req *http.Request) (string, error) {
+		cookie, err := req.Cookie(name)
+		if err != nil || cookie.Value == "" {
+			return "", nil
+		}
+		if csrf != nil {
+			if err := csrf.check(req); err != nil {
+				return "", err
+			}
+		}
+		return cookie.Value, nil
+	}
+}
+
+// check performs the CSRF double submit check.
+func (csrf *CSRF) check(req *http.Request) error {
+	switch req.Method {
+	case "GET", "HEAD", "OPTIONS", "TRACE":
+		return nil
+	}
+	cookieName := csrf.CookieName
+	if cookieName == "" {
+		cookieName = "csrf_token"
+	}
+	headerName := csrf.HeaderName
+	if headerName == "" {
+		headerName = "X-CSRF-Token"
+	}
+	cookie, err := req.Cookie(cookieName)
+	if err != nil || cookie.Value == "" {
+		return ErrCSRFMismatch
+	}
+	header := req.Header.Get(headerName)
+	if subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(header)) != 1 {
+		return ErrCSRFMismatch
+	}
+	return nil
+}
+
+// extractors returns the token extractors of the specification. If the specification does not
+// define any then the token is read from the TokenHeader header and, if AllowParam is true, from
+// the TokenParam querystring parameter.
+func (spec *Specification) extractors() []TokenExtractor {
+	if len(spec.Extractors) > 0 {
+		return spec.Extractors
+	}
+	header := spec.TokenHeader
+	if header == "" {
+		header = JWTHeader
+	}
+	extractors := []TokenExtractor{HeaderExtractor(header, "Bearer")}
+	if spec.AllowParam {
+		param := spec.TokenParam
+		if param == "" {
+			param = "token"
+		}
+		extractors = append(extractors, QueryExtractor(param))
+	}
+	return extractors
+}
@@ -0,0 +1,103 @@
+package jwt_test
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	jwtg "github.com/dgrijalva/jwt-go"
+	"github.com/goadesign/middleware/jwt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("GetToken", func() {
+	var spec *jwt.Specification
+	var tokenString string
+
+	BeforeEach(func() {
+		token := jwtg.New(jwtg.SigningMethodHS256)
+		token.Claims["exp"] = time.Now().Add(time.Hour).Unix()
+		var err error
+		tokenString, err = token.SignedString(signingKey)
+		Ω(err).ShouldNot(HaveOccurred())
+		spec = &jwt.Specification{
+			ValidationFunc: func(*jwtg.Token) (interface{}, error) { return signingKey, nil },
+		}
+	})
+
+	It("reads the Authorization header by default", func() {
+		req, _ := http.NewRequest("GET", "/goo", nil)
+		req.Header.Set("Authorization", "Bearer "+tokenString)
+		token, err := jwt.GetToken(req, spec)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(token.Raw).Should(Equal(tokenString))
+	})
+
+	It("tries the extractors in order", func() {
+		spec.Extractors = []jwt.TokenExtractor{
+			jwt.HeaderExtractor("X-Token", "Token"),
+			jwt.CookieExtractor("session", nil),
+			jwt.QueryExtractor("access_token"),
+		}
+		req, _ := http.NewRequest("GET", "/goo?access_token=bogus", nil)
+		req.AddCookie(&http.Cookie{Name: "session", Value: tokenString})
+		token, err := jwt.GetToken(req, spec)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(token.Raw).Should(Equal(tokenString))
+
+		req.Header.Set("X-Token", "Token bogus")
+		_, err = jwt.GetToken(req, spec)
+		Ω(err).Should(HaveOccurred())
+	})
+
+	It("reads form fields", func() {
+		spec.Extractors = []jwt.TokenExtractor{jwt.FormExtractor("access_token")}
+		body := url.Values{"access_token": {tokenString}}.Encode()
+		req, _ := http.NewRequest("POST", "/goo", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		token, err := jwt.GetToken(req, spec)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(token.Raw).Should(Equal(tokenString))
+	})
+
+	It("supports custom extractors", func() {
+		spec.Extractors = []jwt.TokenExtractor{func(req *http.Request) (string, error) {
+			return tokenString, nil
+		}}
+		req, _ := http.NewRequest("GET", "/goo", nil)
+		token, err := jwt.GetToken(req, spec)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(token.Raw).Should(Equal(tokenString))
+	})
+
+	Context("with CSRF protection", func() {
+		var req *http.Request
+
+		BeforeEach(func() {
+			spec.Extractors = []jwt.TokenExtractor{jwt.CookieExtractor("session", &jwt.CSRF{})}
+			req, _ = http.NewRequest("POST", "/goo", nil)
+			req.AddCookie(&http.Cookie{Name: "session", Value: tokenString})
+			req.AddCookie(&http.Cookie{Name: "csrf_token", Value: "secret"})
+		})
+
+		It("accepts requests repeating the CSRF token", func() {
+			req.Header.Set("X-CSRF-Token", "secret")
+			_, err := jwt.GetToken(req, spec)
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+
+		It("rejects requests not repeating the CSRF token", func() {
+			req.Header.Set("X-CSRF-Token", "other")
+			_, err := jwt.GetToken(req, spec)
+			Ω(err).Should(Equal(jwt.ErrCSRFMismatch))
+		})
+
+		It("does not check safe requests", func() {
+			req.Method = "GET"
+			_, err := jwt.GetToken(req, spec)
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+	})
+})
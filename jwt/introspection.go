@@ -0,0 +1,252 @@
+package jwt
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/goadesign/goa"
+)
+
+const (
+	introspectionInactiveCacheTTLDefault = time.Minute
+	introspectionTimeoutDefault          = 10 * time.Second
+	introspectionMaxCacheEntriesDefault  = 10000
+	// introspectionEvictionInterval is the minimum duration between two evictions of expired
+	// cache entries.
+	introspectionEvictionInterval = time.Minute
+)
+
+// Introspection validates opaque access tokens using an OAuth 2.0 token introspection endpoint,
+// see https://tools.ietf.org/html/rfc7662. Introspection results are cached so that the endpoint
+// is called once per token: active tokens are cached until they expire and inactive tokens for
+// InactiveCacheTTL. The cache holds at most MaxCacheEntries results.
+type Introspection struct {
+	// URL is the location of the introspection endpoint.
+	URL string
+	// ClientID is the client identifier used to authenticate with the endpoint.
+	ClientID string
+	// ClientSecret is the client secret used to authenticate with the endpoint.
+	ClientSecret string
+	// Client is the HTTP client used to call the endpoint.
+	// Defaults to a client with a 10 seconds timeout.
+	Client *http.Client
+	// Extractors lists the functions used to extract the token from the request, they are
+	// tried in order and the first token found is used.
+	// Defaults to reading the bearer token from the Authorization header.
+	Extractors []TokenExtractor
+	// AuthOptions is a flag that determines whether a token is required on OPTIONS requests.
+	AuthOptions bool
+	// MaxCacheTTL is the maximum duration during which an active token introspection result
+	// is cached, it bounds the time it takes for tokens revoked by the authorization server
+	// to be rejected.
+	// Optional, active results are cached until the token expires if 0.
+	MaxCacheTTL time.Duration
+	// InactiveCacheTTL is the duration during which an inactive token introspection result
+	// is cached.
+	// Defaults to one minute.
+	InactiveCacheTTL time.Duration
+	// MaxCacheEntries is the maximum number of cached introspection results, the oldest
+	// results are evicted first when the cache is full. It bounds the memory used by the cache
+	// when clients send many distinct tokens.
+	// Defaults to 10000.
+	MaxCacheEntries int
+	// Realm is the realm included in the WWW-Authenticate header of error responses.
+	// Optional, no realm is included if empty.
+	Realm string
+	// ErrorResponder writes the body of error responses.
+	// Defaults to DefaultErrorResponder.
+	ErrorResponder ErrorResponder
+
+	mu    sync.Mutex
+	cache map[[sha256.Size]byte]*list.Element
+	// order lists the cached results from the oldest to the most recently stored.
+	order *list.List
+	// evictedAt is the last time expired cache entries were evicted.
+	evictedAt time.Time
+}
+
+// introspectionResult is a cached introspection response.
+type introspectionResult struct {
+	key       [sha256.Size]byte
+	claims    map[string]interface{}
+	expiresAt time.Time
+}
+
+// NewIntrospection returns an Introspection that calls the endpoint at the given URL using the
+// given client credentials and the default settings.
+func NewIntrospection(url, clientID, clientSecret string) *Introspection {
+	return &Introspection{
+		URL:              url,
+		ClientID:         clientID,
+		ClientSecret:     clientSecret,
+		Client:           &http.Client{Timeout: introspectionTimeoutDefault},
+		InactiveCacheTTL: introspectionInactiveCacheTTLDefault,
+	}
+}
+
+// IntrospectionMiddleware is a middleware that validates the opaque token carried by the request
+// using the given introspection endpoint. The claims returned by the endpoint for active tokens
+// are stored in the context under JWTKey as a *jwt.Token so that ScopeMiddleware and the claim
+// helpers work the same as with Middleware. Requests without an active token are rejected with a
+// WWW-Authenticate header compliant with RFC 6750, errors calling the endpoint are returned to the
+// caller.
+func IntrospectionMiddleware(i *Introspection) goa.Middleware {
+	return func(h goa.Handler) goa.Handler {
+		return func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+			if !i.AuthOptions && req.Method == "OPTIONS" {
+				return h(ctx, rw, req)
+			}
+			tok, err := i.extract(req)
+			if err != nil {
+				return respond(ctx, rw, req, tokenError(err), i.Realm, i.ErrorResponder)
+			}
+			token, err := i.Introspect(tok)
+			if err != nil {
+				return err
+			}
+			if token == nil {
+				return respond(ctx, rw, req, invalidToken("token is not active"), i.Realm, i.ErrorResponder)
+			}
+			ctx = context.WithValue(ctx, JWTKey, token)
+			return h(ctx, rw, req)
+		}
+	}
+}
+
+// Introspect returns the token built from the claims returned by the introspection endpoint for
+// the given opaque token or nil if the token is not active.
+func (i *Introspection) Introspect(tok string) (*jwt.Token, error) {
+	key := sha256.Sum256([]byte(tok))
+	now := time.Now()
+	var res *introspectionResult
+	i.mu.Lock()
+	if e, ok := i.cache[key]; ok {
+		res = e.Value.(*introspectionResult)
+	}
+	i.mu.Unlock()
+	if res == nil || !now.Before(res.expiresAt) {
+		var err error
+		if res, err = i.introspect(tok, now); err != nil {
+			return nil, err
+		}
+		i.store(key, res, now)
+	}
+	if res.claims == nil {
+		return nil, nil
+	}
+	return &jwt.Token{Raw: tok, Header: map[string]interface{}{}, Claims: res.claims, Valid: true}, nil
+}
+
+// introspect calls the introspection endpoint and returns the result to cache.
+func (i *Introspection) introspect(tok string, now time.Time) (*introspectionResult, error) {
+	form := url.Values{"token": {tok}, "token_type_hint": {"access_token"}}
+	req, err := http.NewRequest("POST", i.URL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if i.ClientID != "" {
+		// RFC 6749 section 2.3.1 requires the credentials to be form encoded.
+		req.SetBasicAuth(url.QueryEscape(i.ClientID), url.QueryEscape(i.ClientSecret))
+	}
+	client := i.Client
+	if client == nil {
+		client = &http.Client{Timeout: introspectionTimeoutDefault}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to introspect token: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to introspect token: endpoint responded with status %d", resp.StatusCode)
+	}
+	var claims map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&claims); err != nil {
+		return nil, fmt.Errorf("failed to decode introspection response: %s", err)
+	}
+
+	inactiveTTL := i.InactiveCacheTTL
+	if inactiveTTL == 0 {
+		inactiveTTL = introspectionInactiveCacheTTLDefault
+	}
+	exp, hasExp, _ := timeClaim(claims, "exp")
+	if active, _ := claims["active"].(bool); !active || (hasExp && !now.Before(exp)) {
+		return &introspectionResult{expiresAt: now.Add(inactiveTTL)}, nil
+	}
+	expiresAt := exp
+	if i.MaxCacheTTL > 0 && (!hasExp || now.Add(i.MaxCacheTTL).Before(exp)) {
+		expiresAt = now.Add(i.MaxCacheTTL)
+	} else if !hasExp {
+		// Cache active tokens without expiration time as if they were inactive so that
+		// the endpoint gets called again eventually.
+		expiresAt = now.Add(inactiveTTL)
+	}
+	return &introspectionResult{claims: claims, expiresAt: expiresAt}, nil
+}
+
+// store caches the given introspection result, evicting expired entries at most once per
+// introspectionEvictionInterval and the oldest entries when the cache is full.
+func (i *Introspection) store(key [sha256.Size]byte, res *introspectionResult, now time.Time) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.cache == nil {
+		i.cache = make(map[[sha256.Size]byte]*list.Element)
+		i.order = list.New()
+	}
+	if now.Sub(i.evictedAt) > introspectionEvictionInterval {
+		for _, e := range i.cache {
+			if !now.Before(e.Value.(*introspectionResult).expiresAt) {
+				i.evict(e)
+			}
+		}
+		i.evictedAt = now
+	}
+	if e, ok := i.cache[key]; ok {
+		i.evict(e)
+	}
+	res.key = key
+	i.cache[key] = i.order.PushBack(res)
+	max := i.MaxCacheEntries
+	if max <= 0 {
+		max = introspectionMaxCacheEntriesDefault
+	}
+	for i.order.Len() > max {
+		i.evict(i.order.Front())
+	}
+}
+
+// evict removes the given cache entry. i.mu must be held.
+func (i *Introspection) evict(e *list.Element) {
+	i.order.Remove(e)
+	delete(i.cache, e.Value.(*introspectionResult).key)
+}
+
+// extract returns the token carried by the request using the configured extractors.
+func (i *Introspection) extract(req *http.Request) (string, error) {
+	extractors := i.Extractors
+	if len(extractors) == 0 {
+		extractors = []TokenExtractor{HeaderExtractor(JWTHeader, "Bearer")}
+	}
+	for _, extract := range extractors {
+		tok, err := extract(req)
+		if err != nil {
+			return "", err
+		}
+		if tok != "" {
+			return tok, nil
+		}
+	}
+	return "", ErrTokenNotFound
+}
@@ -0,0 +1,116 @@
+package jwt_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	"github.com/goadesign/goa"
+	"github.com/goadesign/middleware/jwt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Introspection", func() {
+	var server *httptest.Server
+	var responses map[string]map[string]interface{}
+	var calls int
+	var intro *jwt.Introspection
+
+	BeforeEach(func() {
+		calls = 0
+		responses = map[string]map[string]interface{}{
+			"active": {
+				"active": true,
+				"sub":    "alice",
+				"scope":  "read write",
+				"exp":    time.Now().Add(time.Hour).Unix(),
+			},
+		}
+		server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			calls++
+			id, secret, _ := req.BasicAuth()
+			if secret, _ = url.QueryUnescape(secret); id != "client" || secret != "s3cr%t" {
+				rw.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			resp, ok := responses[req.PostFormValue("token")]
+			if !ok {
+				resp = map[string]interface{}{"active": false}
+			}
+			json.NewEncoder(rw).Encode(resp)
+		}))
+		intro = jwt.NewIntrospection(server.URL, "client", "s3cr%t")
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	// introspect chains the introspection middleware with mw.
+	introspect := func(mw goa.Middleware) goa.Middleware {
+		return func(h goa.Handler) goa.Handler {
+			return jwt.IntrospectionMiddleware(intro)(mw(h))
+		}
+	}
+	noop := func(h goa.Handler) goa.Handler { return h }
+
+	It("stores the claims of active tokens in the context", func() {
+		res := serve(introspect(noop), "active")
+		Ω(res.Err).ShouldNot(HaveOccurred())
+		Ω(res.Status).Should(Equal(http.StatusOK))
+		sub, err := jwt.Subject(res.Ctx)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(sub).Should(Equal("alice"))
+	})
+
+	It("rejects inactive tokens", func() {
+		res := serve(introspect(noop), "unknown")
+		Ω(res.Err).ShouldNot(HaveOccurred())
+		Ω(res.Status).Should(Equal(http.StatusUnauthorized))
+	})
+
+	It("requires a token", func() {
+		res := serve(introspect(noop), "")
+		Ω(res.Err).ShouldNot(HaveOccurred())
+		Ω(res.Status).Should(Equal(http.StatusUnauthorized))
+		Ω(calls).Should(Equal(0))
+	})
+
+	It("caches introspection results", func() {
+		serve(introspect(noop), "active")
+		serve(introspect(noop), "active")
+		serve(introspect(noop), "unknown")
+		serve(introspect(noop), "unknown")
+		Ω(calls).Should(Equal(2))
+	})
+
+	It("evicts the oldest results when the cache is full", func() {
+		intro.MaxCacheEntries = 2
+		serve(introspect(noop), "active")
+		serve(introspect(noop), "unknown-1")
+		serve(introspect(noop), "unknown-2")
+		Ω(calls).Should(Equal(3))
+		serve(introspect(noop), "unknown-2")
+		Ω(calls).Should(Equal(3))
+		Ω(serve(introspect(noop), "active").Status).Should(Equal(http.StatusOK))
+		Ω(calls).Should(Equal(4))
+	})
+
+	It("does not cache active results past their expiration", func() {
+		responses["active"]["exp"] = time.Now().Add(-time.Second).Unix()
+		Ω(serve(introspect(noop), "active").Status).Should(Equal(http.StatusUnauthorized))
+	})
+
+	It("works with scope middlewares", func() {
+		Ω(serve(introspect(jwt.RequireScopes("write")), "active").Status).Should(Equal(http.StatusOK))
+		Ω(serve(introspect(jwt.RequireScopes("admin")), "active").Status).Should(Equal(http.StatusForbidden))
+	})
+
+	It("returns endpoint errors", func() {
+		intro.ClientSecret = "wrong"
+		Ω(serve(introspect(noop), "active").Err).Should(HaveOccurred())
+	})
+})
@@ -0,0 +1,204 @@
+package jwt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// EncryptionMethod is the enum that lists the supported JWE key management algorithms.
+type EncryptionMethod int
+
+const (
+	_ = iota
+	// RSAOAEP key management algorithm (RSAES OAEP using SHA-1)
+	RSAOAEP EncryptionMethod = iota
+	// RSAOAEP256 key management algorithm (RSAES OAEP using SHA-256)
+	RSAOAEP256
+	// DirectEncryption key management algorithm, the key is used directly as content
+	// encryption key
+	DirectEncryption
+)
+
+var encryptionmethods = map[EncryptionMethod]string{
+	RSAOAEP:          "RSA-OAEP",
+	RSAOAEP256:       "RSA-OAEP-256",
+	DirectEncryption: "dir",
+}
+
+// contentEncryption is the JWE content encryption algorithm, AES GCM using a 256 bit key.
+const contentEncryption = "A256GCM"
+
+// cekSize is the size in bytes of the A256GCM content encryption key.
+const cekSize = 32
+
+// jweHeader is the protected header of the JWE tokens.
+type jweHeader struct {
+	Alg string `json:"alg"`
+	Enc string `json:"enc"`
+	Cty string `json:"cty,omitempty"`
+}
+
+// isEncrypted returns true if the token uses the JWE compact serialization.
+func isEncrypted(tok string) bool {
+	return strings.Count(tok, ".") == 4
+}
+
+// encrypt wraps the signed token into a JWE token using the specification encryption key. The
+// JWE "cty" header is set to "JWT" to indicate a nested token as defined by RFC 7519 section 5.2.
+func (spec *Specification) encrypt(signed string) (string, error) {
+	key, err := spec.EncryptionKeyFunc()
+	if err != nil {
+		return "", fmt.Errorf("Error retrieving Encryption Key: %v", err)
+	}
+	alg, ok := encryptionmethods[spec.KeyEncryptionMethod]
+	if !ok {
+		return "", fmt.Errorf("unsupported encryption method %d", spec.KeyEncryptionMethod)
+	}
+	var cek, encryptedKey []byte
+	switch spec.KeyEncryptionMethod {
+	case DirectEncryption:
+		k, ok := key.([]byte)
+		if !ok || len(k) != cekSize {
+			return "", fmt.Errorf("direct encryption requires a %d bytes key", cekSize)
+		}
+		cek = k
+	default:
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return "", fmt.Errorf("%s encryption requires a *rsa.PublicKey", alg)
+		}
+		cek = make([]byte, cekSize)
+		if _, err := io.ReadFull(rand.Reader, cek); err != nil {
+			return "", err
+		}
+		if encryptedKey, err = rsa.EncryptOAEP(oaepHash(spec.KeyEncryptionMethod), rand.Reader, pub, cek, nil); err != nil {
+			return "", err
+		}
+	}
+	header, err := json.Marshal(&jweHeader{Alg: alg, Enc: contentEncryption, Cty: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	protected := jwt.EncodeSegment(header)
+	gcm, err := newGCM(cek)
+	if err != nil {
+		return "", err
+	}
+	iv := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nil, iv, []byte(signed), []byte(protected))
+	ciphertext, tag := sealed[:len(sealed)-gcm.Overhead()], sealed[len(sealed)-gcm.Overhead():]
+	return strings.Join([]string{
+		protected,
+		jwt.EncodeSegment(encryptedKey),
+		jwt.EncodeSegment(iv),
+		jwt.EncodeSegment(ciphertext),
+		jwt.EncodeSegment(tag),
+	}, "."), nil
+}
+
+// decrypt returns the signed token nested in the given JWE token. It returns ErrTokenDecryption
+// if the token cannot be decrypted with the specification decryption key or if it uses another
+// key management algorithm than KeyEncryptionMethod. It returns a KeyError if the decryption key
+// cannot be retrieved or has an unexpected type.
+func (spec *Specification) decrypt(tok string) (string, error) {
+	if spec.DecryptionKeyFunc == nil {
+		return "", ErrTokenDecryption
+	}
+	parts := strings.Split(tok, ".")
+	segments := make([][]byte, len(parts))
+	for i, p := range parts {
+		s, err := jwt.DecodeSegment(p)
+		if err != nil {
+			return "", ErrTokenDecryption
+		}
+		segments[i] = s
+	}
+	var header jweHeader
+	if err := json.Unmarshal(segments[0], &header); err != nil {
+		return "", ErrTokenDecryption
+	}
+	if header.Alg != encryptionmethods[spec.KeyEncryptionMethod] || header.Enc != contentEncryption ||
+		!strings.EqualFold(header.Cty, "JWT") {
+		return "", ErrTokenDecryption
+	}
+	key, err := spec.DecryptionKeyFunc()
+	if err != nil {
+		return "", &KeyError{Err: err}
+	}
+	var cek []byte
+	switch spec.KeyEncryptionMethod {
+	case DirectEncryption:
+		k, ok := key.([]byte)
+		if !ok {
+			return "", &KeyError{Err: fmt.Errorf("unexpected key type %T", key)}
+		}
+		if len(segments[1]) != 0 {
+			return "", ErrTokenDecryption
+		}
+		cek = k
+	default:
+		priv, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return "", &KeyError{Err: fmt.Errorf("unexpected key type %T", key)}
+		}
+		if cek, err = rsa.DecryptOAEP(oaepHash(spec.KeyEncryptionMethod), rand.Reader, priv, segments[1], nil); err != nil {
+			return "", ErrTokenDecryption
+		}
+	}
+	gcm, err := newGCM(cek)
+	if err != nil || len(segments[2]) != gcm.NonceSize() || len(segments[4]) != gcm.Overhead() {
+		return "", ErrTokenDecryption
+	}
+	signed, err := gcm.Open(nil, segments[2], append(segments[3], segments[4]...), []byte(parts[0]))
+	if err != nil {
+		return "", ErrTokenDecryption
+	}
+	return string(signed), nil
+}
+
+// unwrap returns the signed token nested in tok if tok is encrypted, tok otherwise. Tokens that
+// are not encrypted are rejected with ErrTokenNotEncrypted if the specification has a decryption
+// key so that the claims meant to be confidential are never accepted in clear.
+func (spec *Specification) unwrap(tok string) (string, error) {
+	if !isEncrypted(tok) {
+		if spec.DecryptionKeyFunc != nil {
+			return "", ErrTokenNotEncrypted
+		}
+		return tok, nil
+	}
+	return spec.decrypt(tok)
+}
+
+// oaepHash returns the hash function used by the given RSA OAEP key management algorithm.
+func oaepHash(method EncryptionMethod) hash.Hash {
+	if method == RSAOAEP256 {
+		return sha256.New()
+	}
+	return sha1.New()
+}
+
+// newGCM returns the AES GCM cipher using the given content encryption key.
+func newGCM(cek []byte) (cipher.AEAD, error) {
+	if len(cek) != cekSize {
+		return nil, fmt.Errorf("invalid content encryption key size")
+	}
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,126 @@
+package jwt_test
+
+import (
+	"crypto/rsa"
+	"errors"
+	"net/http"
+	"strings"
+
+	jwtg "github.com/dgrijalva/jwt-go"
+	"github.com/goadesign/middleware/jwt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Encrypted tokens", func() {
+	var spec *jwt.Specification
+	var tm *jwt.TokenManager
+	var rsaKey *rsa.PrivateKey
+	dirKey := []byte("0123456789abcdef0123456789abcdef")
+
+	BeforeEach(func() {
+		var err error
+		rsaKey, err = jwtg.ParseRSAPrivateKeyFromPEM(rsaSampleKey)
+		Ω(err).ShouldNot(HaveOccurred())
+		spec = &jwt.Specification{
+			KeySigningMethod:    jwt.HMAC256,
+			SigningKeyFunc:      func() (interface{}, error) { return hmacTestKey, nil },
+			ValidationFunc:      func(*jwtg.Token) (interface{}, error) { return hmacTestKey, nil },
+			KeyEncryptionMethod: jwt.RSAOAEP,
+			EncryptionKeyFunc:   func() (interface{}, error) { return &rsaKey.PublicKey, nil },
+			DecryptionKeyFunc:   func() (interface{}, error) { return rsaKey, nil },
+		}
+		tm = jwt.NewTokenManager(spec)
+	})
+
+	It("creates encrypted tokens that the middleware decrypts", func() {
+		token, err := tm.Create(map[string]interface{}{"tenant": "acme"})
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(strings.Split(token, ".")).Should(HaveLen(5))
+		Ω(token).ShouldNot(ContainSubstring(jwtg.EncodeSegment([]byte(`"acme"`))))
+		res := serve(jwt.Middleware(spec), token)
+		Ω(res.Err).ShouldNot(HaveOccurred())
+		Ω(res.Status).Should(Equal(http.StatusOK))
+		tenant, err := jwt.ClaimString(res.Ctx, "tenant")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(tenant).Should(Equal("acme"))
+	})
+
+	It("supports direct encryption", func() {
+		spec.KeyEncryptionMethod = jwt.DirectEncryption
+		spec.EncryptionKeyFunc = func() (interface{}, error) { return dirKey, nil }
+		spec.DecryptionKeyFunc = spec.EncryptionKeyFunc
+		token, err := tm.Create(map[string]interface{}{"tenant": "acme"})
+		Ω(err).ShouldNot(HaveOccurred())
+		res := serve(jwt.Middleware(spec), token)
+		Ω(res.Err).ShouldNot(HaveOccurred())
+		Ω(res.Status).Should(Equal(http.StatusOK))
+	})
+
+	It("supports RSA-OAEP-256", func() {
+		spec.KeyEncryptionMethod = jwt.RSAOAEP256
+		token, err := tm.Create(map[string]interface{}{"tenant": "acme"})
+		Ω(err).ShouldNot(HaveOccurred())
+		res := serve(jwt.Middleware(spec), token)
+		Ω(res.Err).ShouldNot(HaveOccurred())
+		Ω(res.Status).Should(Equal(http.StatusOK))
+	})
+
+	It("rejects tokens encrypted with another key management algorithm", func() {
+		token, err := tm.Create(map[string]interface{}{"tenant": "acme"})
+		Ω(err).ShouldNot(HaveOccurred())
+		spec.KeyEncryptionMethod = jwt.RSAOAEP256
+		res := serve(jwt.Middleware(spec), token)
+		Ω(res.Err).ShouldNot(HaveOccurred())
+		Ω(res.Status).Should(Equal(http.StatusUnauthorized))
+	})
+
+	It("rejects tampered tokens", func() {
+		token, err := tm.Create(map[string]interface{}{"tenant": "acme"})
+		Ω(err).ShouldNot(HaveOccurred())
+		parts := strings.Split(token, ".")
+		parts[3] = jwtg.EncodeSegment([]byte("tampered"))
+		res := serve(jwt.Middleware(spec), strings.Join(parts, "."))
+		Ω(res.Err).ShouldNot(HaveOccurred())
+		Ω(res.Status).Should(Equal(http.StatusUnauthorized))
+	})
+
+	It("rejects encrypted tokens without decryption key", func() {
+		token, err := tm.Create(map[string]interface{}{"tenant": "acme"})
+		Ω(err).ShouldNot(HaveOccurred())
+		spec.DecryptionKeyFunc = nil
+		res := serve(jwt.Middleware(spec), token)
+		Ω(res.Err).ShouldNot(HaveOccurred())
+		Ω(res.Status).Should(Equal(http.StatusUnauthorized))
+	})
+
+	It("rejects tokens that are not encrypted", func() {
+		spec.EncryptionKeyFunc = nil
+		token, err := tm.Create(map[string]interface{}{"tenant": "acme"})
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(strings.Split(token, ".")).Should(HaveLen(3))
+		res := serve(jwt.Middleware(spec), token)
+		Ω(res.Err).ShouldNot(HaveOccurred())
+		Ω(res.Status).Should(Equal(http.StatusUnauthorized))
+		Ω(res.Writer.Header().Get("WWW-Authenticate")).Should(ContainSubstring("token is not encrypted"))
+	})
+
+	It("returns decryption key retrieval failures instead of blaming the client", func() {
+		token, err := tm.Create(map[string]interface{}{"tenant": "acme"})
+		Ω(err).ShouldNot(HaveOccurred())
+		spec.DecryptionKeyFunc = func() (interface{}, error) { return nil, errors.New("vault unavailable") }
+		res := serve(jwt.Middleware(spec), token)
+		Ω(res.Err).Should(BeAssignableToTypeOf(&jwt.KeyError{}))
+		Ω(res.Err.Error()).Should(ContainSubstring("vault unavailable"))
+		Ω(res.Status).ShouldNot(Equal(http.StatusBadRequest))
+		Ω(res.Writer.Header().Get("WWW-Authenticate")).Should(BeEmpty())
+	})
+
+	It("refreshes encrypted refresh tokens", func() {
+		pair, err := tm.CreatePair(map[string]interface{}{"tenant": "acme"})
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(strings.Split(pair.RefreshToken, ".")).Should(HaveLen(5))
+		_, err = tm.Refresh(pair.RefreshToken)
+		Ω(err).ShouldNot(HaveOccurred())
+	})
+})
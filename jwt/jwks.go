@@ -0,0 +1,319 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"golang.org/x/crypto/ed25519"
+)
+
+const (
+	jwksCacheTTLDefault           = time.Hour
+	jwksMinRefreshIntervalDefault = time.Minute
+	jwksTimeoutDefault            = 10 * time.Second
+)
+
+// JWKS retrieves and caches the keys published by a JSON Web Key Set endpoint, see
+// https://tools.ietf.org/html/rfc7517. Its Keyfunc method may be used as a Specification
+// ValidationFunc so that tokens get validated with the key identified by their "kid" header.
+type JWKS struct {
+	// URL is the location of the JSON Web Key Set document.
+	URL string
+	// Client is the HTTP client used to retrieve the key set.
+	// Defaults to a client with a 10 seconds timeout.
+	Client *http.Client
+	// CacheTTL is the duration during which a retrieved key set is used before being
+	// retrieved again.
+	// Defaults to one hour.
+	CacheTTL time.Duration
+	// MinRefreshInterval is the minimum duration between two retrievals of the key set.
+	// It prevents a flow of tokens with unknown key IDs from flooding the endpoint.
+	// Defaults to one minute.
+	MinRefreshInterval time.Duration
+	// OnInvalidKey is called with the ID of each key of the key set that cannot be used, for
+	// example because of an unsupported key type or curve, and the reason why. Such keys are
+	// skipped, retrieving the key set fails only if it contains no usable key.
+	// Optional, invalid keys are skipped silently if nil.
+	OnInvalidKey func(kid string, err error)
+
+	mu        sync.RWMutex
+	keys      map[string]interface{}
+	fetchedAt time.Time
+	// refreshMu serializes retrievals of the key set.
+	refreshMu sync.Mutex
+	triedAt   time.Time
+}
+
+// jsonWebKey is the JSON representation of a single key in a JSON Web Key Set.
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Use string `json:"use,omitempty"`
+	Kid string `json:"kid,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// jsonWebKeySet is the JSON representation of a JSON Web Key Set.
+type jsonWebKeySet struct {
+	Keys []*jsonWebKey `json:"keys"`
+}
+
+// NewJWKS returns a JWKS that retrieves the key set from the given URL using the default
+// settings.
+func NewJWKS(url string) *JWKS {
+	return &JWKS{
+		URL:                url,
+		Client:             &http.Client{Timeout: jwksTimeoutDefault},
+		CacheTTL:           jwksCacheTTLDefault,
+		MinRefreshInterval: jwksMinRefreshIntervalDefault,
+	}
+}
+
+// Keyfunc returns the key used to validate the given token. The key is selected using the
+// token "kid" header, if the token has no "kid" header then the key set must contain a single
+// key. The key set is retrieved again if it expired or if the token refers to an unknown key ID.
+// Retrievals happen at most once per MinRefreshInterval, the cached keys keep being used if a
+// retrieval fails.
+func (j *JWKS) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, found, expired := j.lookup(kid)
+	if !found || expired {
+		if err := j.refresh(true); err != nil && !found {
+			return nil, err
+		}
+		key, found, _ = j.lookup(kid)
+	}
+	if !found {
+		if kid == "" {
+			return nil, fmt.Errorf("token has no kid header and key set does not contain exactly one key")
+		}
+		return nil, fmt.Errorf("unknown key ID %q", kid)
+	}
+	if err := checkKeyType(token, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Refresh retrieves the key set from the endpoint, replacing the cached keys.
+func (j *JWKS) Refresh() error {
+	return j.refresh(false)
+}
+
+// lookup returns the cached key with the given ID, whether it was found and whether the cache
+// expired.
+func (j *JWKS) lookup(kid string) (key interface{}, found, expired bool) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	if j.keys == nil {
+		return nil, false, true
+	}
+	expired = j.CacheTTL > 0 && time.Since(j.fetchedAt) > j.CacheTTL
+	if kid == "" {
+		if len(j.keys) == 1 {
+			for _, k := range j.keys {
+				return k, true, expired
+			}
+		}
+		return nil, false, expired
+	}
+	key, found = j.keys[kid]
+	return key, found, expired
+}
+
+// refresh retrieves the key set. If limited is true then the key set is not retrieved if the
+// previous attempt happened less than MinRefreshInterval ago.
+func (j *JWKS) refresh(limited bool) error {
+	j.refreshMu.Lock()
+	defer j.refreshMu.Unlock()
+	if limited && !j.triedAt.IsZero() && time.Since(j.triedAt) < j.MinRefreshInterval {
+		return fmt.Errorf("key set refresh rate limited")
+	}
+	j.triedAt = time.Now()
+	keys, err := j.fetch()
+	if err != nil {
+		return err
+	}
+	j.mu.Lock()
+	j.keys = keys
+	j.fetchedAt = time.Now()
+	j.mu.Unlock()
+	return nil
+}
+
+// fetch retrieves and parses the key set.
+func (j *JWKS) fetch() (map[string]interface{}, error) {
+	client := j.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Get(j.URL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve key set: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to retrieve key set: %s", resp.Status)
+	}
+	var set jsonWebKeySet
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("failed to decode key set: %s", err)
+	}
+	keys := make(map[string]interface{}, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			j.invalidKey(jwk.Kid, err)
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("key set contains no usable key")
+	}
+	return keys, nil
+}
+
+// invalidKey reports a key of the key set that cannot be used.
+func (j *JWKS) invalidKey(kid string, err error) {
+	if j.OnInvalidKey != nil {
+		j.OnInvalidKey(kid, err)
+	}
+}
+
+// publicKey returns the *rsa.PublicKey, *ecdsa.PublicKey or ed25519.PublicKey described by the
+// JWK.
+func (k *jsonWebKey) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus: %s", err)
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exponent: %s", err)
+		}
+		if e.BitLen() > 31 {
+			return nil, fmt.Errorf("exponent too large")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid x coordinate: %s", err)
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid y coordinate: %s", err)
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("point is not on curve %s", k.Crv)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := jwt.DecodeSegment(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid public key")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+// checkKeyType makes sure the key type matches the token signing algorithm.
+func checkKeyType(token *jwt.Token, key interface{}) error {
+	alg, _ := token.Header["alg"].(string)
+	var ok bool
+	switch {
+	case strings.HasPrefix(alg, "RS"), strings.HasPrefix(alg, "PS"):
+		_, ok = key.(*rsa.PublicKey)
+	case strings.HasPrefix(alg, "ES"):
+		_, ok = key.(*ecdsa.PublicKey)
+	case alg == "EdDSA":
+		_, ok = key.(ed25519.PublicKey)
+	}
+	if !ok {
+		return fmt.Errorf("key type does not match signing algorithm %q", alg)
+	}
+	return nil
+}
+
+// decodeBigInt decodes a base64url encoded big-endian unsigned integer.
+func decodeBigInt(s string) (*big.Int, error) {
+	if s == "" {
+		return nil, fmt.Errorf("missing value")
+	}
+	b, err := jwt.DecodeSegment(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// newJSONWebKey returns the JWK describing the given *rsa.PublicKey, *ecdsa.PublicKey or
+// ed25519.PublicKey.
+func newJSONWebKey(kid, alg string, key interface{}) (*jsonWebKey, error) {
+	jwk := &jsonWebKey{Kid: kid, Alg: alg, Use: "sig"}
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = jwt.EncodeSegment(k.N.Bytes())
+		jwk.E = jwt.EncodeSegment(big.NewInt(int64(k.E)).Bytes())
+	case *ecdsa.PublicKey:
+		params := k.Curve.Params()
+		size := (params.BitSize + 7) / 8
+		jwk.Kty = "EC"
+		jwk.Crv = params.Name
+		jwk.X = jwt.EncodeSegment(padBytes(k.X.Bytes(), size))
+		jwk.Y = jwt.EncodeSegment(padBytes(k.Y.Bytes(), size))
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = jwt.EncodeSegment(k)
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+	return jwk, nil
+}
+
+// padBytes left pads b with zeros so that it is size bytes long.
+func padBytes(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	padded := make([]byte, size)
+	copy(padded[size-len(b):], b)
+	return padded
+}
@@ -0,0 +1,167 @@
+package jwt_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	jwtg "github.com/dgrijalva/jwt-go"
+	"github.com/goadesign/middleware/jwt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("JWKS", func() {
+	var rsaKey *rsa.PrivateKey
+	var ecKey *ecdsa.PrivateKey
+	var keys []map[string]string
+	var hits int
+	var server *httptest.Server
+	var jwks *jwt.JWKS
+
+	sign := func(method jwtg.SigningMethod, kid string, key interface{}) *jwtg.Token {
+		token := jwtg.New(method)
+		if kid != "" {
+			token.Header["kid"] = kid
+		}
+		token.Claims["exp"] = time.Now().Add(time.Hour).Unix()
+		s, err := token.SignedString(key)
+		Ω(err).ShouldNot(HaveOccurred())
+		parsed, _ := jwtg.Parse(s, nil)
+		return parsed
+	}
+
+	BeforeEach(func() {
+		var err error
+		rsaKey, err = jwtg.ParseRSAPrivateKeyFromPEM(rsaSampleKey)
+		Ω(err).ShouldNot(HaveOccurred())
+		ecKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Ω(err).ShouldNot(HaveOccurred())
+		keys = []map[string]string{
+			{
+				"kty": "RSA",
+				"kid": "rsa",
+				"use": "sig",
+				"n":   jwtg.EncodeSegment(rsaKey.N.Bytes()),
+				"e":   jwtg.EncodeSegment(big.NewInt(int64(rsaKey.E)).Bytes()),
+			},
+			{
+				"kty": "EC",
+				"kid": "ec",
+				"crv": "P-256",
+				"x":   jwtg.EncodeSegment(ecKey.X.Bytes()),
+				"y":   jwtg.EncodeSegment(ecKey.Y.Bytes()),
+			},
+		}
+		hits = 0
+		server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			hits++
+			json.NewEncoder(rw).Encode(map[string]interface{}{"keys": keys})
+		}))
+		jwks = jwt.NewJWKS(server.URL)
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("selects RSA keys by kid", func() {
+		key, err := jwks.Keyfunc(sign(jwtg.SigningMethodRS256, "rsa", rsaKey))
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(key).Should(Equal(&rsaKey.PublicKey))
+	})
+
+	It("selects EC keys by kid", func() {
+		key, err := jwks.Keyfunc(sign(jwtg.SigningMethodES256, "ec", ecKey))
+		Ω(err).ShouldNot(HaveOccurred())
+		pub := key.(*ecdsa.PublicKey)
+		Ω(pub.X).Should(Equal(ecKey.X))
+		Ω(pub.Y).Should(Equal(ecKey.Y))
+	})
+
+	It("validates tokens when used as the specification validation function", func() {
+		token := jwtg.New(jwtg.SigningMethodRS256)
+		token.Header["kid"] = "rsa"
+		token.Claims["exp"] = time.Now().Add(time.Hour).Unix()
+		s, err := token.SignedString(rsaKey)
+		Ω(err).ShouldNot(HaveOccurred())
+		parsed, err := jwtg.Parse(s, jwtg.Keyfunc(jwks.Keyfunc))
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(parsed.Valid).Should(BeTrue())
+	})
+
+	It("caches the key set", func() {
+		_, err := jwks.Keyfunc(sign(jwtg.SigningMethodRS256, "rsa", rsaKey))
+		Ω(err).ShouldNot(HaveOccurred())
+		_, err = jwks.Keyfunc(sign(jwtg.SigningMethodES256, "ec", ecKey))
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(hits).Should(Equal(1))
+	})
+
+	It("rejects keys that do not match the signing algorithm", func() {
+		_, err := jwks.Keyfunc(sign(jwtg.SigningMethodES256, "rsa", ecKey))
+		Ω(err).Should(HaveOccurred())
+	})
+
+	Context("with unusable keys", func() {
+		var skipped []string
+
+		BeforeEach(func() {
+			skipped = nil
+			jwks.OnInvalidKey = func(kid string, err error) {
+				skipped = append(skipped, kid)
+			}
+		})
+
+		It("skips them", func() {
+			keys = append(keys,
+				map[string]string{"kty": "oct", "kid": "hmac", "k": "c2VjcmV0"},
+				map[string]string{"kty": "EC", "kid": "secp256k1", "crv": "secp256k1", "x": "AA", "y": "AA"},
+				map[string]string{"kty": "RSA", "kid": "enc", "use": "enc", "n": "AA", "e": "AQAB"},
+			)
+			key, err := jwks.Keyfunc(sign(jwtg.SigningMethodRS256, "rsa", rsaKey))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(key).Should(Equal(&rsaKey.PublicKey))
+			Ω(skipped).Should(ConsistOf("hmac", "secp256k1"))
+		})
+
+		It("skips them silently by default", func() {
+			jwks.OnInvalidKey = nil
+			keys = append(keys, map[string]string{"kty": "oct", "kid": "hmac", "k": "c2VjcmV0"})
+			_, err := jwks.Keyfunc(sign(jwtg.SigningMethodRS256, "rsa", rsaKey))
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+
+		It("fails when no usable key remains", func() {
+			keys = []map[string]string{{"kty": "oct", "kid": "hmac", "k": "c2VjcmV0"}}
+			Ω(jwks.Refresh()).Should(HaveOccurred())
+			Ω(skipped).Should(ConsistOf("hmac"))
+		})
+	})
+
+	Context("with an unknown kid", func() {
+		It("refreshes the key set", func() {
+			_, err := jwks.Keyfunc(sign(jwtg.SigningMethodRS256, "rsa", rsaKey))
+			Ω(err).ShouldNot(HaveOccurred())
+			keys[0]["kid"] = "rotated"
+			jwks.MinRefreshInterval = 0
+			_, err = jwks.Keyfunc(sign(jwtg.SigningMethodRS256, "rotated", rsaKey))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(hits).Should(Equal(2))
+		})
+
+		It("rate limits refreshes", func() {
+			_, err := jwks.Keyfunc(sign(jwtg.SigningMethodRS256, "unknown", rsaKey))
+			Ω(err).Should(HaveOccurred())
+			_, err = jwks.Keyfunc(sign(jwtg.SigningMethodRS256, "unknown", rsaKey))
+			Ω(err).Should(HaveOccurred())
+			Ω(hits).Should(Equal(1))
+		})
+	})
+})
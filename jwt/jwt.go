@@ -1,9 +1,8 @@
 package jwt
 
 import (
-	"fmt"
 	"net/http"
-	"strings"
+	"time"
 
 	"golang.org/x/net/context"
 
@@ -34,6 +33,14 @@ const (
 	ECDSA384
 	// ECDSA512 signing algorithm
 	ECDSA512
+	// RSAPSS256 signing algorithm
+	RSAPSS256
+	// RSAPSS384 signing algorithm
+	RSAPSS384
+	// RSAPSS512 signing algorithm
+	RSAPSS512
+	// EdDSA signing algorithm using Ed25519 keys
+	EdDSA
 )
 
 var signingmethods map[SigningMethod]string
@@ -42,7 +49,7 @@ func init() {
 	signingmethods = make(map[SigningMethod]string)
 
 	signingmethods[RSA256] = "RS256"
-	signingmethods[RSA384] = "RS512"
+	signingmethods[RSA384] = "RS384"
 	signingmethods[RSA512] = "RS512"
 	signingmethods[HMAC256] = "HS256"
 	signingmethods[HMAC384] = "HS384"
@@ -50,6 +57,10 @@ func init() {
 	signingmethods[ECDSA256] = "ES256"
 	signingmethods[ECDSA384] = "ES384"
 	signingmethods[ECDSA512] = "ES512"
+	signingmethods[RSAPSS256] = "PS256"
+	signingmethods[RSAPSS384] = "PS384"
+	signingmethods[RSAPSS512] = "PS512"
+	signingmethods[EdDSA] = "EdDSA"
 }
 
 // JWTKey is the JWT middleware key used to store the token in the context.
@@ -66,15 +77,9 @@ const JWTHeader = "Authorization"
 // to return.
 type ValidationKeyfunc func(*jwt.Token) (interface{}, error)
 
-func keyFuncWrapper(k ValidationKeyfunc) jwt.Keyfunc {
-	return func(tok *jwt.Token) (interface{}, error) {
-		return k(tok)
-	}
-}
-
 // KeyFunc is a function that returns the key to sign a
 // token.  It should return a []byte (for all)
-// or a *rsa.PrivateKey or *ecdsa.PrivateKey
+// or a *rsa.PrivateKey, *ecdsa.PrivateKey or ed25519.PrivateKey
 type KeyFunc func() (interface{}, error)
 
 // Specification describes the JWT authorization properties.
@@ -92,6 +97,11 @@ type Specification struct {
 	// to parse tokens from the querystring
 	// Defaults to false
 	AllowParam bool
+	// Extractors lists the functions used to extract the token from the
+	// request, they are tried in order and the first token found is used
+	// Defaults to reading the TokenHeader header and, if AllowParam is true,
+	// the TokenParam querystring parameter
+	Extractors []TokenExtractor
 	// ValidationFunc is a function that returns the key to validate the JWT
 	// Required, no default
 	ValidationFunc ValidationKeyfunc
@@ -103,48 +113,101 @@ type Specification struct {
 	// RefreshTTLMinutes is the TTL for refresh tokens that are generated
 	// and should generally be much longer than TTLMinutes
 	RefreshTTLMinutes int
+	// RotateRefreshTokens is a flag that determines whether refresh tokens
+	// may only be used once, each exchange producing a new refresh token
+	// Defaults to false
+	RotateRefreshTokens bool
+	// RefreshStore records the refresh tokens issued when RotateRefreshTokens
+	// is true
+	// Defaults to an in-memory store
+	RefreshStore RefreshStore
 	// Issuer is the name of the issuer that will be inserted into the
 	// generated token's claims
 	Issuer string
 	// KeySigningMethod determines the type of key that will be used to sign
-	// Tokens.
+	// Tokens. When set the middleware also rejects tokens signed with a
+	// different algorithm.
 	KeySigningMethod SigningMethod
 	// SigningKeyFunc is a function that returns the key used to sign the token
 	SigningKeyFunc KeyFunc
+	// Keyring holds the keys used to sign and validate tokens, when set the
+	// token manager signs tokens with the keyring current key and the
+	// middleware validates tokens with the keyring if ValidationFunc is nil
+	// Optional, overrides KeySigningMethod and SigningKeyFunc
+	Keyring *Keyring
+	// KeyEncryptionMethod is the JWE key management algorithm used to encrypt and
+	// decrypt tokens, the token content is encrypted with A256GCM
+	// Required if EncryptionKeyFunc or DecryptionKeyFunc is set
+	KeyEncryptionMethod EncryptionMethod
+	// EncryptionKeyFunc is a function that returns the key used to encrypt the
+	// signed tokens created by the token manager: a *rsa.PublicKey for the
+	// RSA OAEP methods or a 32 bytes []byte for DirectEncryption
+	// Optional, tokens are not encrypted if nil
+	EncryptionKeyFunc KeyFunc
+	// DecryptionKeyFunc is a function that returns the key used to decrypt
+	// encrypted tokens: a *rsa.PrivateKey for the RSA OAEP methods or a 32 bytes
+	// []byte for DirectEncryption
+	// Optional, encrypted tokens are rejected if nil. Tokens that are not encrypted are
+	// rejected if set
+	DecryptionKeyFunc KeyFunc
 	// CommonClaims is a list of claims added to all tokens issued
 	CommonClaims map[string]interface{}
+	// ValidIssuers lists the accepted values for the "iss" claim of incoming tokens
+	// Optional, any issuer is accepted if empty
+	ValidIssuers []string
+	// ValidAudiences lists the accepted values for the "aud" claim of incoming
+	// tokens, tokens must have at least one of these audiences
+	// Optional, any audience is accepted if empty
+	ValidAudiences []string
+	// RequiredClaims lists the names of the claims incoming tokens must have
+	RequiredClaims []string
+	// ClockSkew is the leeway allowed when validating the "exp", "nbf" and "iat"
+	// claims of incoming tokens
+	// Defaults to 0
+	ClockSkew time.Duration
+	// MaxTokenAge is the maximum age of incoming tokens computed from their
+	// "iat" claim, tokens without "iat" claim are rejected when set
+	// Optional, the age is not checked if 0
+	MaxTokenAge time.Duration
+	// Realm is the realm included in the WWW-Authenticate header of error
+	// responses
+	// Optional, no realm is included if empty
+	Realm string
+	// ErrorResponder writes the body of error responses
+	// Defaults to DefaultErrorResponder
+	ErrorResponder ErrorResponder
+	// RevocationStore keeps track of the tokens revoked with TokenManager.Revoke,
+	// the middleware rejects revoked tokens
+	// Optional, tokens cannot be revoked if nil
+	RevocationStore RevocationStore
 }
 
-// GetToken extracts the JWT token from the request if there is one.
+// GetToken extracts the JWT token from the request if there is one. It validates the token
+// signature and claims, claim validation failures are reported with a *ClaimError.
 func GetToken(req *http.Request, spec *Specification) (token *jwt.Token, err error) {
-	var found bool
 	var tok string
-	header := req.Header.Get(spec.TokenHeader)
-
-	if header != "" {
-		parts := strings.Split(header, " ")
-		if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
-			err = fmt.Errorf("Malformed token header")
+	for _, extract := range spec.extractors() {
+		if tok, err = extract(req); err != nil {
 			return
 		}
-		tok = parts[1]
-		found = true
-	}
-	if !found && spec.AllowParam {
-		tok = req.URL.Query().Get(spec.TokenParam)
+		if tok != "" {
+			break
+		}
 	}
 	if tok == "" {
-		err = fmt.Errorf("no token")
+		err = ErrTokenNotFound
 		return
 	}
-	token, err = jwt.Parse(tok, keyFuncWrapper(spec.ValidationFunc))
+	token, err = spec.parse(tok)
 	return
 }
 
 // Middleware is a middleware that retrieves a JWT token from the request if present and
 // injects it into the context.  It checks for the token in the HTTP Headers first, then the querystring if
-// the specification "AllowParam" is true.
+// the specification "AllowParam" is true, unless the specification defines custom Extractors.
 // Retrieve it using ctx.Value(JWTKey).
+// Requests without a valid token are rejected with a WWW-Authenticate header compliant with
+// RFC 6750, the response body is written by the specification ErrorResponder.
 func Middleware(spec *Specification) goa.Middleware {
 	if spec.TokenHeader == "" {
 		spec.TokenHeader = "Authorization"
@@ -161,10 +224,24 @@ func Middleware(spec *Specification) goa.Middleware {
 			}
 			token, err := GetToken(req, spec)
 			if err != nil {
-				return goa.Response(ctx).Send(ctx, http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized))
+				if _, ok := err.(*KeyError); ok {
+					return err
+				}
+				return respond(ctx, rw, req, tokenError(err), spec.Realm, spec.ErrorResponder)
 			}
 			if !token.Valid {
-				return goa.Response(ctx).Send(ctx, http.StatusUnauthorized, "Invalid Token")
+				return respond(ctx, rw, req, invalidToken("token is invalid"), spec.Realm, spec.ErrorResponder)
+			}
+			if token.Claims[typeClaim] == refreshTokenType {
+				return respond(ctx, rw, req, invalidToken("refresh tokens cannot be used as access tokens"),
+					spec.Realm, spec.ErrorResponder)
+			}
+			revoked, err := isRevoked(spec, token)
+			if err != nil {
+				return err
+			}
+			if revoked {
+				return respond(ctx, rw, req, invalidToken("token is revoked"), spec.Realm, spec.ErrorResponder)
 			}
 
 			ctx = context.WithValue(ctx, JWTKey, token)
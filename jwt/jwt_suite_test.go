@@ -1,6 +1,11 @@
 package jwt_test
 
 import (
+	"net/http"
+
+	"golang.org/x/net/context"
+
+	"github.com/goadesign/goa"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
@@ -11,3 +16,41 @@ func TestJWT(t *testing.T) {
 	RegisterFailHandler(Fail)
 	RunSpecs(t, "JWT Suite")
 }
+
+// newContext returns the goa context, response writer and request of a "GET /goo" test request
+// carrying the given bearer token if not empty.
+func newContext(token string) (context.Context, *TestResponseWriter, *http.Request) {
+	req, _ := http.NewRequest("GET", "/goo", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rw := new(TestResponseWriter)
+	ctx := goa.NewContext(goa.New("test").NewController("test").Context, rw, req, nil)
+	return ctx, rw, req
+}
+
+// testResponse describes the outcome of a test request sent with serve.
+type testResponse struct {
+	// Status is the response status.
+	Status int
+	// Writer is the response writer.
+	Writer *TestResponseWriter
+	// Ctx is the context given to the handler, nil if the handler was not called.
+	Ctx context.Context
+	// Err is the error returned by the middleware.
+	Err error
+}
+
+// serve sends a test request carrying the given bearer token through the middleware to a handler
+// that responds with 200.
+func serve(mw goa.Middleware, token string) *testResponse {
+	ctx, rw, req := newContext(token)
+	res := &testResponse{Writer: rw}
+	h := func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+		res.Ctx = ctx
+		return goa.Response(ctx).Send(ctx, http.StatusOK, "ok")
+	}
+	res.Err = mw(h)(ctx, rw, req)
+	res.Status = goa.Response(ctx).Status
+	return res
+}
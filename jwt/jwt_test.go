@@ -204,6 +204,9 @@ type TestResponseWriter struct {
 }
 
 func (t *TestResponseWriter) Header() http.Header {
+	if t.ParentHeader == nil {
+		t.ParentHeader = make(http.Header)
+	}
 	return t.ParentHeader
 }
 
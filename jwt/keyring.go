@@ -0,0 +1,174 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"golang.org/x/crypto/ed25519"
+)
+
+// Key is a key held by a Keyring.
+type Key struct {
+	// ID identifies the key, it is stamped in the "kid" header of the tokens signed with the
+	// key.
+	ID string
+	// Method is the signing method used with the key.
+	Method SigningMethod
+	// SigningKey is the key used to sign tokens: a []byte for HMAC methods, a
+	// *rsa.PrivateKey for RSA and RSA-PSS methods, a *ecdsa.PrivateKey for ECDSA methods
+	// or a ed25519.PrivateKey for EdDSA.
+	SigningKey interface{}
+	// ActivatesAt is the time from which the key is used to sign tokens. Keys are accepted
+	// for validation before they activate so that they can be published ahead of time.
+	// Optional, the key is active immediately if zero.
+	ActivatesAt time.Time
+	// RetiresAt is the time from which the key is neither used to sign nor to validate tokens.
+	// Optional, the key never retires if zero.
+	RetiresAt time.Time
+}
+
+// Keyring holds the keys used to sign and validate tokens, making it possible to rotate keys
+// without coordinating the token issuers and validators: a new key is added to the keyring
+// ahead of its activation time and the previous key is retired once the tokens it signed
+// expired.
+type Keyring struct {
+	mu   sync.RWMutex
+	keys []*Key
+}
+
+// NewKeyring returns a keyring holding the given keys.
+func NewKeyring(keys ...*Key) *Keyring {
+	return &Keyring{keys: keys}
+}
+
+// Add adds a key to the keyring.
+func (k *Keyring) Add(key *Key) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys = append(k.keys, key)
+}
+
+// Remove removes the key with the given ID from the keyring.
+func (k *Keyring) Remove(id string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	keys := k.keys[:0]
+	for _, key := range k.keys {
+		if key.ID != id {
+			keys = append(keys, key)
+		}
+	}
+	k.keys = keys
+}
+
+// Current returns the key used to sign new tokens: the active key with the most recent
+// activation time.
+func (k *Keyring) Current() (*Key, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	now := time.Now()
+	var current *Key
+	for _, key := range k.keys {
+		if key.ActivatesAt.After(now) || key.retired(now) {
+			continue
+		}
+		if current == nil || key.ActivatesAt.After(current.ActivatesAt) {
+			current = key
+		}
+	}
+	if current == nil {
+		return nil, fmt.Errorf("no active key in keyring")
+	}
+	return current, nil
+}
+
+// Keyfunc returns the key used to validate the given token. The key is selected using the
+// token "kid" header, retired keys are not accepted and the token algorithm must match the key
+// signing method. Keyfunc may be used as a Specification ValidationFunc.
+func (k *Keyring) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		return nil, fmt.Errorf("token has no kid header")
+	}
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	for _, key := range k.keys {
+		if key.ID != kid {
+			continue
+		}
+		if key.retired(time.Now()) {
+			return nil, fmt.Errorf("key %q is retired", kid)
+		}
+		if alg, _ := token.Header["alg"].(string); alg != signingmethods[key.Method] {
+			return nil, fmt.Errorf("token algorithm %q does not match key %q", alg, kid)
+		}
+		return publicKey(key.SigningKey)
+	}
+	return nil, fmt.Errorf("unknown key ID %q", kid)
+}
+
+// JWKSHandler returns a HTTP handler that responds with the JSON Web Key Set containing the public
+// keys of the non retired asymmetric keys of the keyring. HMAC keys are never published.
+func (k *Keyring) JWKSHandler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		set, err := k.jwks()
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		rw.Header().Set("Content-Type", "application/jwk-set+json")
+		json.NewEncoder(rw).Encode(set)
+	})
+}
+
+// jwks returns the JSON Web Key Set containing the public keys of the keyring.
+func (k *Keyring) jwks() (*jsonWebKeySet, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	set := &jsonWebKeySet{Keys: []*jsonWebKey{}}
+	now := time.Now()
+	for _, key := range k.keys {
+		if key.retired(now) {
+			continue
+		}
+		if _, ok := key.SigningKey.([]byte); ok {
+			continue
+		}
+		pub, err := publicKey(key.SigningKey)
+		if err != nil {
+			return nil, err
+		}
+		jwk, err := newJSONWebKey(key.ID, signingmethods[key.Method], pub)
+		if err != nil {
+			return nil, err
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set, nil
+}
+
+// retired returns true if the key is retired at the given time.
+func (key *Key) retired(now time.Time) bool {
+	return !key.RetiresAt.IsZero() && !now.Before(key.RetiresAt)
+}
+
+// publicKey returns the key used to validate the signatures made with the given signing key.
+func publicKey(signingKey interface{}) (interface{}, error) {
+	switch k := signingKey.(type) {
+	case []byte:
+		return k, nil
+	case *rsa.PrivateKey:
+		return &k.PublicKey, nil
+	case *ecdsa.PrivateKey:
+		return &k.PublicKey, nil
+	case ed25519.PrivateKey:
+		return k.Public(), nil
+	}
+	return nil, fmt.Errorf("unsupported signing key type %T", signingKey)
+}
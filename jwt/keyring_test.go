@@ -0,0 +1,98 @@
+package jwt_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	jwtg "github.com/dgrijalva/jwt-go"
+	"github.com/goadesign/middleware/jwt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Keyring", func() {
+	var oldKey, newKey *jwt.Key
+	var keyring *jwt.Keyring
+	var spec *jwt.Specification
+	var tm *jwt.TokenManager
+
+	BeforeEach(func() {
+		rsaKey, err := jwtg.ParseRSAPrivateKeyFromPEM(rsaSampleKey)
+		Ω(err).ShouldNot(HaveOccurred())
+		ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Ω(err).ShouldNot(HaveOccurred())
+		oldKey = &jwt.Key{ID: "old", Method: jwt.RSA256, SigningKey: rsaKey}
+		newKey = &jwt.Key{ID: "new", Method: jwt.ECDSA256, SigningKey: ecKey, ActivatesAt: time.Now().Add(time.Hour)}
+		keyring = jwt.NewKeyring(oldKey, newKey, &jwt.Key{ID: "hmac", Method: jwt.HMAC256, SigningKey: hmacTestKey, RetiresAt: time.Now().Add(time.Hour)})
+		spec = &jwt.Specification{Keyring: keyring}
+		tm = jwt.NewTokenManager(spec)
+	})
+
+	kid := func(token string) interface{} {
+		t, _ := jwtg.Parse(token, nil)
+		return t.Header["kid"]
+	}
+
+	It("signs with the current key and stamps the kid header", func() {
+		token, err := tm.Create(map[string]interface{}{"sub": "alice"})
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(kid(token)).Should(Equal("old"))
+		t, err := jwtg.Parse(token, jwtg.Keyfunc(keyring.Keyfunc))
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(t.Valid).Should(BeTrue())
+	})
+
+	It("switches to new keys once they activate", func() {
+		newKey.ActivatesAt = time.Now().Add(-time.Second)
+		token, err := tm.Create(map[string]interface{}{"sub": "alice"})
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(kid(token)).Should(Equal("new"))
+	})
+
+	It("validates tokens signed with keys that are not active yet", func() {
+		other := jwt.NewTokenManager(&jwt.Specification{Keyring: jwt.NewKeyring(&jwt.Key{
+			ID: "new", Method: jwt.ECDSA256, SigningKey: newKey.SigningKey})})
+		token, err := other.Create(map[string]interface{}{"sub": "alice"})
+		Ω(err).ShouldNot(HaveOccurred())
+		_, err = jwtg.Parse(token, jwtg.Keyfunc(keyring.Keyfunc))
+		Ω(err).ShouldNot(HaveOccurred())
+	})
+
+	It("rejects tokens signed with retired keys", func() {
+		token, err := tm.Create(map[string]interface{}{"sub": "alice"})
+		Ω(err).ShouldNot(HaveOccurred())
+		oldKey.RetiresAt = time.Now().Add(-time.Second)
+		_, err = jwtg.Parse(token, jwtg.Keyfunc(keyring.Keyfunc))
+		Ω(err).Should(HaveOccurred())
+	})
+
+	It("publishes the public keys as a JWKS document", func() {
+		rw := httptest.NewRecorder()
+		keyring.JWKSHandler().ServeHTTP(rw, nil)
+		Ω(rw.Code).Should(Equal(http.StatusOK))
+		var set struct {
+			Keys []map[string]string `json:"keys"`
+		}
+		Ω(json.Unmarshal(rw.Body.Bytes(), &set)).ShouldNot(HaveOccurred())
+		Ω(set.Keys).Should(HaveLen(2))
+		Ω(set.Keys[0]["kid"]).Should(Equal("old"))
+		Ω(set.Keys[0]["kty"]).Should(Equal("RSA"))
+		Ω(set.Keys[0]).ShouldNot(HaveKey("d"))
+		Ω(set.Keys[1]["kid"]).Should(Equal("new"))
+		Ω(set.Keys[1]["crv"]).Should(Equal("P-256"))
+	})
+
+	It("publishes keys that JWKS can consume", func() {
+		server := httptest.NewServer(keyring.JWKSHandler())
+		defer server.Close()
+		token, err := tm.Create(map[string]interface{}{"sub": "alice"})
+		Ω(err).ShouldNot(HaveOccurred())
+		_, err = jwtg.Parse(token, jwtg.Keyfunc(jwt.NewJWKS(server.URL).Keyfunc))
+		Ω(err).ShouldNot(HaveOccurred())
+	})
+})
@@ -0,0 +1,100 @@
+package jwt
+
+import (
+	"crypto/rand"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+const (
+	// typeClaim is the name of the claim that identifies refresh tokens.
+	typeClaim = "typ"
+	// refreshTokenType is the value of the type claim for refresh tokens.
+	refreshTokenType = "refresh"
+	// familyClaim is the name of the claim that identifies the original refresh token a
+	// rotated refresh token derives from.
+	familyClaim = "fam"
+)
+
+// refreshReservedClaims lists the claims set by the token manager that are not carried over
+// when exchanging a refresh token.
+var refreshReservedClaims = map[string]bool{
+	typeClaim:   true,
+	familyClaim: true,
+	"jti":       true,
+	"iat":       true,
+	"exp":       true,
+	"nbf":       true,
+}
+
+// ErrRefreshTokenReused is returned by TokenManager.Refresh when refresh token rotation is
+// enabled and the refresh token was already exchanged or its family was revoked.
+var ErrRefreshTokenReused = errors.New("refresh token reuse detected")
+
+// RefreshStore keeps track of the refresh tokens issued by a TokenManager when refresh token
+// rotation is enabled. Refresh tokens derived from the same original token belong to the same
+// family, only the latest token of a family may be exchanged.
+type RefreshStore interface {
+	// Issue records the refresh token with the given ID as the only valid token of family.
+	Issue(family, id string, expiresAt time.Time) error
+	// Consume invalidates the refresh token with the given ID. It returns
+	// ErrRefreshTokenReused and revokes the whole family if the token is not the valid token
+	// of the family.
+	Consume(family, id string) error
+}
+
+// MemoryRefreshStore is a RefreshStore that keeps track of refresh tokens in memory.
+// It is suitable for single process deployments and tests.
+type MemoryRefreshStore struct {
+	sync.Mutex
+	families map[string]*refreshEntry
+}
+
+// refreshEntry records the valid refresh token of a family.
+type refreshEntry struct {
+	id        string
+	expiresAt time.Time
+}
+
+// NewMemoryRefreshStore returns an empty in-memory refresh token store.
+func NewMemoryRefreshStore() *MemoryRefreshStore {
+	return &MemoryRefreshStore{families: make(map[string]*refreshEntry)}
+}
+
+// Issue records the refresh token with the given ID as the only valid token of family.
+// It also evicts the families whose latest token expired.
+func (s *MemoryRefreshStore) Issue(family, id string, expiresAt time.Time) error {
+	s.Lock()
+	defer s.Unlock()
+	now := time.Now()
+	for f, e := range s.families {
+		if now.After(e.expiresAt) {
+			delete(s.families, f)
+		}
+	}
+	s.families[family] = &refreshEntry{id: id, expiresAt: expiresAt}
+	return nil
+}
+
+// Consume invalidates the refresh token with the given ID, see RefreshStore.
+func (s *MemoryRefreshStore) Consume(family, id string) error {
+	s.Lock()
+	defer s.Unlock()
+	e, ok := s.families[family]
+	delete(s.families, family)
+	if !ok || e.id != id || time.Now().After(e.expiresAt) {
+		return ErrRefreshTokenReused
+	}
+	return nil
+}
+
+// newTokenID returns a random URL safe token identifier.
+func newTokenID() string {
+	b := make([]byte, 16)
+	io.ReadFull(rand.Reader, b)
+	return jwt.EncodeSegment(b)
+}
@@ -0,0 +1,118 @@
+package jwt_test
+
+import (
+	"net/http"
+
+	"golang.org/x/net/context"
+
+	jwtg "github.com/dgrijalva/jwt-go"
+	"github.com/goadesign/goa"
+	"github.com/goadesign/middleware/jwt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Refresh tokens", func() {
+	var spec *jwt.Specification
+	var tm *jwt.TokenManager
+	claims := map[string]interface{}{"sub": "alice"}
+	keyFunc := func(*jwtg.Token) (interface{}, error) {
+		return hmacTestKey, nil
+	}
+
+	BeforeEach(func() {
+		spec = &jwt.Specification{
+			Issuer:           "goa",
+			KeySigningMethod: jwt.HMAC256,
+			SigningKeyFunc:   func() (interface{}, error) { return hmacTestKey, nil },
+			ValidationFunc:   keyFunc,
+		}
+	})
+
+	JustBeforeEach(func() {
+		tm = jwt.NewTokenManager(spec)
+	})
+
+	It("creates access and refresh tokens", func() {
+		pair, err := tm.CreatePair(claims)
+		Ω(err).ShouldNot(HaveOccurred())
+		access, err := jwtg.Parse(pair.AccessToken, keyFunc)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(access.Claims["sub"]).Should(Equal("alice"))
+		Ω(access.Claims).ShouldNot(HaveKey("typ"))
+		refresh, err := jwtg.Parse(pair.RefreshToken, keyFunc)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(refresh.Claims["sub"]).Should(Equal("alice"))
+		Ω(refresh.Claims["typ"]).Should(Equal("refresh"))
+		Ω(refresh.Claims["exp"]).Should(BeNumerically(">", access.Claims["exp"]))
+	})
+
+	It("exchanges a refresh token for a new pair", func() {
+		pair, err := tm.CreatePair(claims)
+		Ω(err).ShouldNot(HaveOccurred())
+		newPair, err := tm.Refresh(pair.RefreshToken)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(newPair.RefreshToken).Should(Equal(pair.RefreshToken))
+		access, err := jwtg.Parse(newPair.AccessToken, keyFunc)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(access.Claims["sub"]).Should(Equal("alice"))
+		Ω(access.Claims).ShouldNot(HaveKey("typ"))
+	})
+
+	It("refuses to exchange access tokens", func() {
+		pair, err := tm.CreatePair(claims)
+		Ω(err).ShouldNot(HaveOccurred())
+		_, err = tm.Refresh(pair.AccessToken)
+		Ω(err).Should(HaveOccurred())
+	})
+
+	It("validates the refresh token claims against the specification", func() {
+		pair, err := tm.CreatePair(claims)
+		Ω(err).ShouldNot(HaveOccurred())
+		spec.ValidIssuers = []string{"other"}
+		_, err = tm.Refresh(pair.RefreshToken)
+		Ω(err).Should(MatchError(ContainSubstring(`token issuer "goa" is not accepted`)))
+	})
+
+	It("is rejected by the middleware", func() {
+		pair, err := tm.CreatePair(claims)
+		Ω(err).ShouldNot(HaveOccurred())
+		req, _ := http.NewRequest("GET", "/goo", nil)
+		req.Header.Set("Authorization", "Bearer "+pair.RefreshToken)
+		rw := new(TestResponseWriter)
+		s := goa.New("test")
+		ctx := goa.NewContext(s.NewController("test").Context, rw, req, nil)
+		h := func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+			panic("unreachable")
+		}
+		Ω(jwt.Middleware(spec)(h)(ctx, rw, req)).ShouldNot(HaveOccurred())
+		Ω(goa.Response(ctx).Status).Should(Equal(http.StatusUnauthorized))
+	})
+
+	Context("with rotation", func() {
+		BeforeEach(func() {
+			spec.RotateRefreshTokens = true
+		})
+
+		It("issues a new refresh token on each exchange", func() {
+			pair, err := tm.CreatePair(claims)
+			Ω(err).ShouldNot(HaveOccurred())
+			newPair, err := tm.Refresh(pair.RefreshToken)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(newPair.RefreshToken).ShouldNot(Equal(pair.RefreshToken))
+			_, err = tm.Refresh(newPair.RefreshToken)
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+
+		It("revokes the token family on reuse", func() {
+			pair, err := tm.CreatePair(claims)
+			Ω(err).ShouldNot(HaveOccurred())
+			newPair, err := tm.Refresh(pair.RefreshToken)
+			Ω(err).ShouldNot(HaveOccurred())
+			_, err = tm.Refresh(pair.RefreshToken)
+			Ω(err).Should(Equal(jwt.ErrRefreshTokenReused))
+			_, err = tm.Refresh(newPair.RefreshToken)
+			Ω(err).Should(Equal(jwt.ErrRefreshTokenReused))
+		})
+	})
+})
@@ -0,0 +1,73 @@
+package jwt
+
+import (
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// RevocationStore keeps track of revoked tokens. Tokens are identified by their "jti" claim
+// which TokenManager sets on all the tokens it creates.
+type RevocationStore interface {
+	// Revoke records the token with the given ID as revoked. expiresAt is the token
+	// expiration time after which the record is no longer needed.
+	Revoke(id string, expiresAt time.Time) error
+	// IsRevoked returns true if the token with the given ID was revoked.
+	IsRevoked(id string) (bool, error)
+}
+
+// MemoryRevocationStore is a RevocationStore that keeps track of revoked tokens in memory.
+// Records are evicted once the corresponding token expires.
+type MemoryRevocationStore struct {
+	sync.RWMutex
+	revoked map[string]time.Time
+	// evictedAt is the last time expired records were evicted.
+	evictedAt time.Time
+}
+
+// revocationEvictionInterval is the minimum duration between two evictions of expired records.
+const revocationEvictionInterval = time.Minute
+
+// NewMemoryRevocationStore returns an empty in-memory revocation store.
+func NewMemoryRevocationStore() *MemoryRevocationStore {
+	return &MemoryRevocationStore{revoked: make(map[string]time.Time)}
+}
+
+// Revoke records the token with the given ID as revoked until expiresAt.
+func (s *MemoryRevocationStore) Revoke(id string, expiresAt time.Time) error {
+	s.Lock()
+	defer s.Unlock()
+	now := time.Now()
+	if now.Sub(s.evictedAt) > revocationEvictionInterval {
+		for k, exp := range s.revoked {
+			if now.After(exp) {
+				delete(s.revoked, k)
+			}
+		}
+		s.evictedAt = now
+	}
+	s.revoked[id] = expiresAt
+	return nil
+}
+
+// IsRevoked returns true if the token with the given ID was revoked and has not expired yet.
+func (s *MemoryRevocationStore) IsRevoked(id string) (bool, error) {
+	s.RLock()
+	defer s.RUnlock()
+	exp, ok := s.revoked[id]
+	return ok && !time.Now().After(exp), nil
+}
+
+// isRevoked returns true if the specification has a revocation store and the token ID is
+// recorded as revoked in it.
+func isRevoked(spec *Specification, token *jwt.Token) (bool, error) {
+	if spec.RevocationStore == nil {
+		return false, nil
+	}
+	id, ok := token.Claims["jti"].(string)
+	if !ok || id == "" {
+		return false, nil
+	}
+	return spec.RevocationStore.IsRevoked(id)
+}
@@ -0,0 +1,102 @@
+package jwt_test
+
+import (
+	"net/http"
+	"time"
+
+	jwtg "github.com/dgrijalva/jwt-go"
+	"github.com/goadesign/middleware/jwt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Token revocation", func() {
+	var spec *jwt.Specification
+	var tm *jwt.TokenManager
+	var token string
+	keyFunc := func(*jwtg.Token) (interface{}, error) {
+		return hmacTestKey, nil
+	}
+
+	BeforeEach(func() {
+		spec = &jwt.Specification{
+			KeySigningMethod: jwt.HMAC256,
+			SigningKeyFunc:   func() (interface{}, error) { return hmacTestKey, nil },
+			ValidationFunc:   keyFunc,
+			RevocationStore:  jwt.NewMemoryRevocationStore(),
+		}
+		tm = jwt.NewTokenManager(spec)
+		var err error
+		token, err = tm.Create(map[string]interface{}{"sub": "alice"})
+		Ω(err).ShouldNot(HaveOccurred())
+	})
+
+	It("adds a jti claim to created tokens", func() {
+		t, err := jwtg.Parse(token, keyFunc)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(t.Claims["jti"]).ShouldNot(BeEmpty())
+	})
+
+	It("rejects revoked tokens", func() {
+		res := serve(jwt.Middleware(spec), token)
+		Ω(res.Err).ShouldNot(HaveOccurred())
+		Ω(res.Status).Should(Equal(http.StatusOK))
+		Ω(tm.Revoke(token)).ShouldNot(HaveOccurred())
+		res = serve(jwt.Middleware(spec), token)
+		Ω(res.Err).ShouldNot(HaveOccurred())
+		Ω(res.Status).Should(Equal(http.StatusUnauthorized))
+	})
+
+	It("refuses to exchange revoked refresh tokens", func() {
+		pair, err := tm.CreatePair(map[string]interface{}{"sub": "alice"})
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(tm.Revoke(pair.RefreshToken)).ShouldNot(HaveOccurred())
+		_, err = tm.Refresh(pair.RefreshToken)
+		Ω(err).Should(HaveOccurred())
+	})
+
+	It("revokes expired tokens still accepted within the clock skew", func() {
+		spec.ClockSkew = time.Minute
+		t := jwtg.New(jwtg.SigningMethodHS256)
+		t.Claims["jti"] = "expired-1"
+		t.Claims["exp"] = time.Now().Add(-10 * time.Second).Unix()
+		expired, err := t.SignedString(hmacTestKey)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(serve(jwt.Middleware(spec), expired).Status).Should(Equal(http.StatusOK))
+		Ω(tm.Revoke(expired)).ShouldNot(HaveOccurred())
+		Ω(serve(jwt.Middleware(spec), expired).Status).Should(Equal(http.StatusUnauthorized))
+	})
+
+	It("ignores tokens expired past the clock skew", func() {
+		t := jwtg.New(jwtg.SigningMethodHS256)
+		t.Claims["jti"] = "expired-2"
+		t.Claims["exp"] = time.Now().Add(-10 * time.Second).Unix()
+		expired, err := t.SignedString(hmacTestKey)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(tm.Revoke(expired)).ShouldNot(HaveOccurred())
+	})
+
+	It("requires a revocation store", func() {
+		spec.RevocationStore = nil
+		Ω(tm.Revoke(token)).Should(HaveOccurred())
+	})
+})
+
+var _ = Describe("MemoryRevocationStore", func() {
+	var store *jwt.MemoryRevocationStore
+
+	BeforeEach(func() {
+		store = jwt.NewMemoryRevocationStore()
+	})
+
+	It("records revoked token IDs", func() {
+		Ω(store.Revoke("id", time.Now().Add(time.Hour))).ShouldNot(HaveOccurred())
+		Ω(store.IsRevoked("id")).Should(BeTrue())
+		Ω(store.IsRevoked("other")).Should(BeFalse())
+	})
+
+	It("forgets expired records", func() {
+		Ω(store.Revoke("id", time.Now().Add(-time.Second))).ShouldNot(HaveOccurred())
+		Ω(store.IsRevoked("id")).Should(BeFalse())
+	})
+})
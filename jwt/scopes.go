@@ -0,0 +1,110 @@
+package jwt
+
+import (
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/goadesign/goa"
+)
+
+// ScopeSpecification describes the scopes a request token must have.
+type ScopeSpecification struct {
+	// Scopes lists the required scopes.
+	Scopes []string
+	// Any is a flag that determines whether a single scope in Scopes suffices
+	// Defaults to false, all scopes are required
+	Any bool
+	// Claim is the name of the token claim that lists the token scopes, either
+	// as a space separated string or as an array of strings
+	// Defaults to "scope" falling back to "scopes"
+	Claim string
+	// Realm is the realm included in the WWW-Authenticate header of error
+	// responses
+	// Optional, no realm is included if empty
+	Realm string
+	// ErrorResponder writes the body of error responses
+	// Defaults to DefaultErrorResponder
+	ErrorResponder ErrorResponder
+}
+
+// RequireScopes returns a middleware that requires the token stored in the context by Middleware
+// to have all the given scopes.
+func RequireScopes(scopes ...string) goa.Middleware {
+	return ScopeMiddleware(&ScopeSpecification{Scopes: scopes})
+}
+
+// RequireAnyScope returns a middleware that requires the token stored in the context by
+// Middleware to have at least one of the given scopes.
+func RequireAnyScope(scopes ...string) goa.Middleware {
+	return ScopeMiddleware(&ScopeSpecification{Scopes: scopes, Any: true})
+}
+
+// ScopeMiddleware returns a middleware that checks the scopes of the token stored in the context
+// by Middleware against the given specification. It responds with 401 if there is no token and
+// with 403 if the token lacks the required scopes, the latter response WWW-Authenticate header
+// includes the "insufficient_scope" error code as defined by RFC 6750.
+// The middleware must be mounted after Middleware.
+func ScopeMiddleware(spec *ScopeSpecification) goa.Middleware {
+	return func(h goa.Handler) goa.Handler {
+		return func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+			token, ok := ctx.Value(JWTKey).(*jwt.Token)
+			if !ok {
+				e := &AuthError{Status: http.StatusUnauthorized}
+				return respond(ctx, rw, req, e, spec.Realm, spec.ErrorResponder)
+			}
+			if !spec.satisfiedBy(tokenScopes(token, spec.Claim)) {
+				e := &AuthError{
+					Status:      http.StatusForbidden,
+					Code:        ErrorCodeInsufficientScope,
+					Description: "token lacks required scopes",
+					Scope:       strings.Join(spec.Scopes, " "),
+				}
+				return respond(ctx, rw, req, e, spec.Realm, spec.ErrorResponder)
+			}
+			return h(ctx, rw, req)
+		}
+	}
+}
+
+// satisfiedBy returns true if the given scopes satisfy the specification.
+func (spec *ScopeSpecification) satisfiedBy(scopes []string) bool {
+	for _, s := range spec.Scopes {
+		found := contains(scopes, s)
+		if spec.Any && found {
+			return true
+		}
+		if !spec.Any && !found {
+			return false
+		}
+	}
+	return !spec.Any || len(spec.Scopes) == 0
+}
+
+// tokenScopes returns the scopes listed in the token claim with the given name, if name is empty
+// the "scope" claim is used falling back to the "scopes" claim.
+func tokenScopes(token *jwt.Token, name string) []string {
+	var claim interface{}
+	if name != "" {
+		claim = token.Claims[name]
+	} else if c, ok := token.Claims["scope"]; ok {
+		claim = c
+	} else {
+		claim = token.Claims["scopes"]
+	}
+	switch c := claim.(type) {
+	case string:
+		return strings.Fields(c)
+	case []interface{}:
+		scopes := make([]string, 0, len(c))
+		for _, s := range c {
+			if str, ok := s.(string); ok {
+				scopes = append(scopes, str)
+			}
+		}
+		return scopes
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package jwt_test
+
+import (
+	"net/http"
+
+	"golang.org/x/net/context"
+
+	jwtg "github.com/dgrijalva/jwt-go"
+	"github.com/goadesign/goa"
+	"github.com/goadesign/middleware/jwt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Scopes", func() {
+	var token *jwtg.Token
+	var rw *TestResponseWriter
+	var ctx context.Context
+	var req *http.Request
+	var called bool
+
+	h := func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+		called = true
+		return goa.Response(ctx).Send(ctx, http.StatusOK, "ok")
+	}
+
+	BeforeEach(func() {
+		token = jwtg.New(jwtg.SigningMethodHS256)
+		token.Claims["scope"] = "read write"
+		ctx, rw, req = newContext("")
+		ctx = context.WithValue(ctx, jwt.JWTKey, token)
+		called = false
+	})
+
+	It("accepts tokens with all the required scopes", func() {
+		Ω(jwt.RequireScopes("read", "write")(h)(ctx, rw, req)).ShouldNot(HaveOccurred())
+		Ω(called).Should(BeTrue())
+	})
+
+	It("rejects tokens missing a required scope", func() {
+		Ω(jwt.RequireScopes("read", "admin")(h)(ctx, rw, req)).ShouldNot(HaveOccurred())
+		Ω(called).Should(BeFalse())
+		Ω(goa.Response(ctx).Status).Should(Equal(http.StatusForbidden))
+		Ω(rw.Header().Get("WWW-Authenticate")).Should(Equal(`Bearer error="insufficient_scope", error_description="token lacks required scopes", scope="read admin"`))
+	})
+
+	It("accepts tokens with any of the scopes", func() {
+		Ω(jwt.RequireAnyScope("admin", "write")(h)(ctx, rw, req)).ShouldNot(HaveOccurred())
+		Ω(called).Should(BeTrue())
+	})
+
+	It("rejects tokens with none of the scopes", func() {
+		Ω(jwt.RequireAnyScope("admin", "root")(h)(ctx, rw, req)).ShouldNot(HaveOccurred())
+		Ω(called).Should(BeFalse())
+		Ω(goa.Response(ctx).Status).Should(Equal(http.StatusForbidden))
+	})
+
+	It("reads scopes from arrays", func() {
+		delete(token.Claims, "scope")
+		token.Claims["scopes"] = []interface{}{"read", "admin"}
+		Ω(jwt.RequireScopes("admin")(h)(ctx, rw, req)).ShouldNot(HaveOccurred())
+		Ω(called).Should(BeTrue())
+	})
+
+	It("reads scopes from a configured claim", func() {
+		token.Claims["permissions"] = "admin"
+		spec := &jwt.ScopeSpecification{Scopes: []string{"admin"}, Claim: "permissions"}
+		Ω(jwt.ScopeMiddleware(spec)(h)(ctx, rw, req)).ShouldNot(HaveOccurred())
+		Ω(called).Should(BeTrue())
+	})
+
+	It("requires a token", func() {
+		ctx, rw, req = newContext("")
+		Ω(jwt.RequireScopes("read")(h)(ctx, rw, req)).ShouldNot(HaveOccurred())
+		Ω(called).Should(BeFalse())
+		Ω(goa.Response(ctx).Status).Should(Equal(http.StatusUnauthorized))
+	})
+})
@@ -18,6 +18,15 @@ type TokenManager struct {
 	spec *Specification
 }
 
+// TokenPair holds an access token and the refresh token that can be exchanged for a new pair
+// once the access token expires.
+type TokenPair struct {
+	// AccessToken is the token used to authorize requests.
+	AccessToken string `json:"access_token"`
+	// RefreshToken is the token used to obtain a new pair.
+	RefreshToken string `json:"refresh_token"`
+}
+
 // NewTokenManager returns a TokenManager.  If TTLMinutes isn't specified
 // it will default to 5 minutes.  Use the same Specification as you use for
 // Middleware() to ensure your tokens are compatible.
@@ -28,14 +37,141 @@ func NewTokenManager(spec *Specification) *TokenManager {
 	if spec.RefreshTTLMinutes == 0 {
 		spec.RefreshTTLMinutes = refreshttldefault
 	}
+	if spec.RotateRefreshTokens && spec.RefreshStore == nil {
+		spec.RefreshStore = NewMemoryRefreshStore()
+	}
 	return &TokenManager{spec: spec}
 }
 
 // Create makes a new token, adding the claims provided.  It returns
 // a token as a string.
 func (tm *TokenManager) Create(claims map[string]interface{}) (string, error) {
+	return tm.sign(claims, nil, tm.spec.TTLMinutes)
+}
+
+// CreatePair makes a new access token and a new refresh token, both carrying the claims
+// provided. The refresh token has a "typ" claim set to "refresh" so that the middleware
+// rejects it when used as an access token.
+func (tm *TokenManager) CreatePair(claims map[string]interface{}) (*TokenPair, error) {
+	access, err := tm.Create(claims)
+	if err != nil {
+		return nil, err
+	}
+	refresh, err := tm.createRefresh(claims, newTokenID())
+	if err != nil {
+		return nil, err
+	}
+	return &TokenPair{AccessToken: access, RefreshToken: refresh}, nil
+}
+
+// Refresh validates the given refresh token and exchanges it for a new pair. If the
+// specification RotateRefreshTokens field is true the refresh token is consumed and the pair
+// contains a new refresh token, presenting a consumed refresh token again revokes all the
+// refresh tokens derived from the same original token and returns ErrRefreshTokenReused.
+// Otherwise the pair contains the refresh token given as argument.
+func (tm *TokenManager) Refresh(refreshToken string) (*TokenPair, error) {
+	if tm.spec.validationFunc() == nil {
+		return nil, fmt.Errorf("no validation function to verify refresh token")
+	}
+	token, err := tm.spec.parse(refreshToken)
+	if err != nil {
+		return nil, fmt.Errorf("invalid refresh token: %s", err)
+	}
+	if !token.Valid || token.Claims[typeClaim] != refreshTokenType {
+		return nil, fmt.Errorf("invalid refresh token")
+	}
+	if revoked, err := isRevoked(tm.spec, token); err != nil {
+		return nil, err
+	} else if revoked {
+		return nil, fmt.Errorf("refresh token revoked")
+	}
+	claims := make(map[string]interface{}, len(token.Claims))
+	for k, v := range token.Claims {
+		if !refreshReservedClaims[k] {
+			claims[k] = v
+		}
+	}
+	access, err := tm.Create(claims)
+	if err != nil {
+		return nil, err
+	}
+	if !tm.spec.RotateRefreshTokens {
+		return &TokenPair{AccessToken: access, RefreshToken: refreshToken}, nil
+	}
+	family, _ := token.Claims[familyClaim].(string)
+	id, _ := token.Claims["jti"].(string)
+	if err := tm.spec.RefreshStore.Consume(family, id); err != nil {
+		return nil, err
+	}
+	refresh, err := tm.createRefresh(claims, family)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenPair{AccessToken: access, RefreshToken: refresh}, nil
+}
 
-	t := jwt.New(jwt.GetSigningMethod(signingmethods[tm.spec.KeySigningMethod]))
+// Revoke revokes the given token so that it gets rejected by the middleware and, for refresh
+// tokens, by Refresh until it expires. The token must have been created by a TokenManager using
+// the same specification and the specification RevocationStore must be set.
+func (tm *TokenManager) Revoke(token string) error {
+	if tm.spec.RevocationStore == nil {
+		return fmt.Errorf("no revocation store")
+	}
+	if tm.spec.validationFunc() == nil {
+		return fmt.Errorf("no validation function to verify token")
+	}
+	t, err := tm.spec.parse(token)
+	if err != nil {
+		if ce, ok := err.(*ClaimError); ok && ce.Claim == "exp" {
+			return nil // Tokens expired past the clock skew are rejected anyway
+		}
+		return fmt.Errorf("invalid token: %s", err)
+	}
+	id, ok := t.Claims["jti"].(string)
+	if !ok || id == "" {
+		return fmt.Errorf("token has no jti claim")
+	}
+	// Record the revocation for as long as the middleware may accept the token.
+	exp := time.Now().Add(time.Minute * time.Duration(tm.spec.RefreshTTLMinutes))
+	if e, ok, _ := timeClaim(t.Claims, "exp"); ok {
+		exp = e
+	}
+	return tm.spec.RevocationStore.Revoke(id, exp.Add(tm.spec.ClockSkew))
+}
+
+// createRefresh makes a new refresh token belonging to the given family and records it in the
+// refresh store when rotation is enabled.
+func (tm *TokenManager) createRefresh(claims map[string]interface{}, family string) (string, error) {
+	id := newTokenID()
+	extra := map[string]interface{}{
+		typeClaim: refreshTokenType,
+		"jti":     id,
+	}
+	if tm.spec.RotateRefreshTokens {
+		extra[familyClaim] = family
+		exp := time.Now().Add(time.Minute * time.Duration(tm.spec.RefreshTTLMinutes))
+		if err := tm.spec.RefreshStore.Issue(family, id, exp); err != nil {
+			return "", fmt.Errorf("Error recording refresh token: %v", err)
+		}
+	}
+	return tm.sign(claims, extra, tm.spec.RefreshTTLMinutes)
+}
+
+// sign creates and signs a token with the claims provided, the specification common claims,
+// the extra claims and an expiration time ttl minutes in the future.
+func (tm *TokenManager) sign(claims, extra map[string]interface{}, ttl int) (string, error) {
+	method, key, kid, err := tm.signingKey()
+	if err != nil {
+		return "", err
+	}
+	m := jwt.GetSigningMethod(signingmethods[method])
+	if m == nil {
+		return "", fmt.Errorf("unsupported signing method %d", method)
+	}
+	t := jwt.New(m)
+	if kid != "" {
+		t.Header["kid"] = kid
+	}
 	for k, v := range claims {
 		t.Claims[k] = v
 	}
@@ -47,14 +183,37 @@ func (tm *TokenManager) Create(claims map[string]interface{}) (string, error) {
 	for k, v := range tm.spec.CommonClaims {
 		t.Claims[k] = v
 	}
+	for k, v := range extra {
+		t.Claims[k] = v
+	}
+	// set the token ID used for revocation
+	if _, ok := t.Claims["jti"]; !ok {
+		t.Claims["jti"] = newTokenID()
+	}
 	// set issued at time
 	t.Claims["iat"] = time.Now().Unix()
 	// set the expire time
-	t.Claims["exp"] = time.Now().Add(time.Minute * time.Duration(tm.spec.TTLMinutes)).Unix()
-	bytes, err := tm.spec.SigningKeyFunc()
-	if err != nil {
-		return "", fmt.Errorf("Error retrieving Signing Key: %v", err)
+	t.Claims["exp"] = time.Now().Add(time.Minute * time.Duration(ttl)).Unix()
+	signed, err := t.SignedString(key)
+	if err != nil || tm.spec.EncryptionKeyFunc == nil {
+		return signed, err
 	}
-	return t.SignedString(bytes)
+	return tm.spec.encrypt(signed)
+}
 
+// signingKey returns the signing method, key and key ID used to sign new tokens. The key comes
+// from the specification keyring if there is one, from SigningKeyFunc otherwise.
+func (tm *TokenManager) signingKey() (SigningMethod, interface{}, string, error) {
+	if tm.spec.Keyring != nil {
+		key, err := tm.spec.Keyring.Current()
+		if err != nil {
+			return 0, nil, "", fmt.Errorf("Error retrieving Signing Key: %v", err)
+		}
+		return key.Method, key.SigningKey, key.ID, nil
+	}
+	key, err := tm.spec.SigningKeyFunc()
+	if err != nil {
+		return 0, nil, "", fmt.Errorf("Error retrieving Signing Key: %v", err)
+	}
+	return tm.spec.KeySigningMethod, key, "", nil
 }
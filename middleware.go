@@ -3,14 +3,12 @@ package middleware
 import (
 	"crypto/rand"
 	"encoding/base64"
-	"errors"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"net/http"
 	"regexp"
-	"runtime"
 	"strings"
-	"sync/atomic"
 	"time"
 
 	"github.com/goadesign/goa"
@@ -48,10 +46,19 @@ func init() {
 type middlewareKey int
 
 // LogRequest creates a request logger middleware.
-// This middleware is aware of the RequestID middleware and if registered after it leverages the
-// request ID for logging.
+// This middleware is aware of the RequestID and Trace middlewares and if registered after them
+// leverages the request ID and the trace and span IDs for logging.
 // If verbose is true then the middlware logs the request and response bodies.
 func LogRequest(verbose bool) goa.Middleware {
+	return LogRequestMiddleware(&LogSpecification{Verbose: verbose})
+}
+
+// LogRequestMiddleware creates a request logger middleware using the given specification.
+// The specification Format field makes it possible to log requests using the Apache Common or
+// Combined Log Formats, JSON lines or a custom template instead of the default "started" and
+// "completed" entries. If the specification Sampling field is set the entries are logged once
+// the request completes and only for the requests selected by the sampling rules.
+func LogRequestMiddleware(spec *LogSpecification) goa.Middleware {
 	return func(h goa.Handler) goa.Handler {
 		return func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
 			reqID := ctx.Value(ReqIDKey)
@@ -59,22 +66,42 @@ func LogRequest(verbose bool) goa.Middleware {
 				reqID = shortID()
 			}
 			ctx = goa.LogWith(ctx, "id", reqID)
+			traceID, _ := ctx.Value(TraceIDKey).(string)
+			spanID, _ := ctx.Value(SpanIDKey).(string)
+			if traceID != "" {
+				ctx = goa.LogWith(ctx, "trace_id", traceID, "span_id", spanID)
+			}
 			startedAt := time.Now()
 			r := goa.Request(ctx)
-			goa.Info(ctx, "started", r.Method, r.URL.String())
-			if verbose {
+			var entries []logEntry
+			if spec.Format == LogFormatKeyValue {
+				entries = append(entries, logEntry{"started", []interface{}{r.Method, spec.Redaction.redactURL(r.URL)}})
+			}
+			if spec.Verbose {
+				if spec.LogHeaders && len(r.Header) > 0 {
+					headers := spec.Redaction.redactHeaders(r.Header)
+					logCtx := make([]interface{}, 2*len(headers))
+					i := 0
+					for k, v := range headers {
+						logCtx[i] = k
+						logCtx[i+1] = interface{}(strings.Join(v, ", "))
+						i = i + 2
+					}
+					entries = append(entries, logEntry{"headers", logCtx})
+				}
 				if len(r.Params) > 0 {
 					logCtx := make([]interface{}, 2*len(r.Params))
 					i := 0
 					for k, v := range r.Params {
 						logCtx[i] = k
-						logCtx[i+1] = interface{}(strings.Join(v, ", "))
+						logCtx[i+1] = interface{}(spec.Redaction.redactParam(k, strings.Join(v, ", ")))
 						i = i + 2
 					}
-					goa.Info(ctx, "params", logCtx...)
+					entries = append(entries, logEntry{"params", logCtx})
 				}
 				if r.ContentLength > 0 {
-					if mp, ok := r.Payload.(map[string]interface{}); ok {
+					payload := spec.Redaction.redactPayload(r.Payload)
+					if mp, ok := payload.(map[string]interface{}); ok {
 						logCtx := make([]interface{}, 2*len(mp))
 						i := 0
 						for k, v := range mp {
@@ -82,119 +109,243 @@ func LogRequest(verbose bool) goa.Middleware {
 							logCtx[i+1] = interface{}(v)
 							i = i + 2
 						}
-						goa.Info(ctx, "payload", logCtx...)
+						entries = append(entries, logEntry{"payload", logCtx})
 					} else {
-						goa.Info(ctx, "payload", r.Payload)
+						entries = append(entries, logEntry{"payload", []interface{}{payload}})
 					}
 				}
 			}
+			if spec.Sampling == nil {
+				logEntries(ctx, entries)
+				entries = nil
+			}
 			err := h(ctx, rw, req)
 			resp := goa.Response(ctx)
-			goa.Info(ctx, "completed", "status", resp.Status,
-				"bytes", resp.Length, "time", time.Since(startedAt).String())
+			latency := time.Since(startedAt)
+			if spec.Sampling != nil {
+				if !spec.Sampling.sampled(req, resp.Status, latency) {
+					return err
+				}
+				logEntries(ctx, entries)
+			}
+			if spec.Format == LogFormatKeyValue {
+				keyvals := []interface{}{"status", resp.Status, "bytes", resp.Length, "time", latency.String()}
+				if spec.isError(resp.Status) {
+					goa.Error(ctx, "completed", keyvals...)
+				} else {
+					goa.Info(ctx, "completed", keyvals...)
+				}
+			} else {
+				id := fmt.Sprintf("%v", reqID)
+				e := newAccessLogEntry(r.Request, resp, id, startedAt, spec.TrustProxyHeaders)
+				e.TraceID, e.SpanID = traceID, spanID
+				if spec.Redaction != nil {
+					e.URI = spec.Redaction.redactURL(r.URL)
+					e.Referer = spec.Redaction.redactString(e.Referer)
+					e.UserAgent = spec.Redaction.redactString(e.UserAgent)
+				}
+				spec.logAccess(ctx, e)
+			}
 			return err
 		}
 	}
 }
 
-// loggingResponseWriter wraps an http.ResponseWriter and writes only raw
-// response data (as text) to the context logger. assumes status and duration
-// are logged elsewhere (i.e. by the LogRequest middleware).
+// logEntry is a log entry whose logging is deferred.
+type logEntry struct {
+	msg     string
+	keyvals []interface{}
+}
+
+// logEntries logs the given entries at info level.
+func logEntries(ctx context.Context, entries []logEntry) {
+	for _, e := range entries {
+		goa.Info(ctx, e.msg, e.keyvals...)
+	}
+}
+
+// loggingResponseWriter wraps an http.ResponseWriter and captures the response status and the
+// beginning of the response body so that the LogResponse middleware can log them once the
+// response is complete. It assumes duration is logged elsewhere (i.e. by the LogRequest
+// middleware).
 type loggingResponseWriter struct {
 	http.ResponseWriter
-	ctx context.Context
+	// maxBodySize is the maximum number of body bytes captured.
+	maxBodySize int
+	status      int
+	body        []byte
+	// length is the total length of the response body.
+	length int
+}
+
+// WriteHeader records the status and writes it to the response writer.
+func (lrw *loggingResponseWriter) WriteHeader(status int) {
+	if lrw.status == 0 {
+		lrw.status = status
+	}
+	lrw.ResponseWriter.WriteHeader(status)
 }
 
-// Write will write raw data to logger and response writer.
+// Write captures the data up to the maximum body size and writes it to the response writer.
 func (lrw *loggingResponseWriter) Write(buf []byte) (int, error) {
-	goa.Info(lrw.ctx, "response", "body", string(buf))
+	if lrw.status == 0 {
+		lrw.status = http.StatusOK
+	}
+	if room := lrw.maxBodySize - len(lrw.body); room > 0 {
+		if room > len(buf) {
+			room = len(buf)
+		}
+		lrw.body = append(lrw.body, buf[:room]...)
+	}
+	lrw.length += len(buf)
 	return lrw.ResponseWriter.Write(buf)
 }
 
+// Flush sends any buffered data to the client if the underlying response writer supports it,
+// it makes it possible to log streaming responses.
+func (lrw *loggingResponseWriter) Flush() {
+	if f, ok := lrw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// BinaryBodyMode is the enum that lists how LogResponse logs binary response bodies.
+type BinaryBodyMode int
+
+const (
+	// BinaryBodySkip does not log binary bodies, only their length is logged.
+	BinaryBodySkip BinaryBodyMode = iota
+	// BinaryBodyHex logs the hexadecimal encoding of the beginning of binary bodies.
+	BinaryBodyHex
+)
+
+const (
+	// logResponseMaxBodySizeDefault is the default maximum number of body bytes logged by
+	// LogResponse.
+	logResponseMaxBodySizeDefault = 4096
+	// hexSummarySize is the number of bytes of binary bodies logged with BinaryBodyHex.
+	hexSummarySize = 64
+)
+
+// LogResponseSpecification describes how the LogResponse middleware logs responses.
+type LogResponseSpecification struct {
+	// MaxBodySize is the maximum number of response body bytes logged, longer bodies are
+	// truncated.
+	// Defaults to 4096.
+	MaxBodySize int
+	// BinaryBodies determines how bodies with a binary content type are logged.
+	// Defaults to BinaryBodySkip.
+	BinaryBodies BinaryBodyMode
+	// Redaction describes the sensitive data removed from the logged headers and bodies.
+	// Optional, headers other than the credential headers and bodies are logged as is if nil.
+	Redaction *Redaction
+	// IncludeCredentials is a flag that determines whether the Set-Cookie, Authorization,
+	// Proxy-Authorization and Cookie response headers are logged. They are removed by default
+	// as they usually carry session tokens.
+	IncludeCredentials bool
+}
+
+// responseCredentialHeaders lists the response headers that are not logged unless
+// IncludeCredentials is set.
+var responseCredentialHeaders = append([]string{"Set-Cookie"}, credentialHeaders...)
+
 // LogResponse creates a response logger middleware.
-// Only Logs the raw response data without accumulating any statistics.
+// It logs the response status, headers and body once the response is complete without
+// accumulating any statistics.
 func LogResponse() goa.Middleware {
+	return LogResponseMiddleware(&LogResponseSpecification{})
+}
+
+// LogResponseMiddleware creates a response logger middleware using the given specification.
+// The middleware logs a single "response" entry per request with the response status, headers
+// and body. Bodies longer than the specification MaxBodySize are truncated.
+func LogResponseMiddleware(spec *LogResponseSpecification) goa.Middleware {
+	maxBodySize := spec.MaxBodySize
+	if maxBodySize == 0 {
+		maxBodySize = logResponseMaxBodySizeDefault
+	}
 	return func(h goa.Handler) goa.Handler {
 		return func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
 			// chain a new logging writer to the current response writer.
 			resp := goa.Response(ctx)
-			resp.SwitchWriter(
-				&loggingResponseWriter{
-					ResponseWriter: resp.SwitchWriter(nil),
-					ctx:            ctx,
-				})
+			lrw := &loggingResponseWriter{
+				ResponseWriter: resp.SwitchWriter(nil),
+				maxBodySize:    maxBodySize,
+			}
+			resp.SwitchWriter(lrw)
 
 			// next
-			return h(ctx, rw, req)
+			err := h(ctx, rw, req)
+			if lrw.status != 0 {
+				goa.Info(ctx, "response", "status", lrw.status,
+					"headers", spec.headers(lrw.Header()), "body", spec.body(lrw))
+			}
+			return err
 		}
 	}
 }
 
-// RequestID is a middleware that injects a request ID into the context of each request.
-// Retrieve it using ctx.Value(ReqIDKey). If the incoming request has a RequestIDHeader header then
-// that value is used else a random value is generated.
-func RequestID() goa.Middleware {
-	return func(h goa.Handler) goa.Handler {
-		return func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
-			id := req.Header.Get(RequestIDHeader)
-			if id == "" {
-				id = fmt.Sprintf("%s-%d", reqPrefix, atomic.AddInt64(&reqID, 1))
-			}
-			ctx = context.WithValue(ctx, ReqIDKey, id)
+// headers returns the logged response headers.
+func (spec *LogResponseSpecification) headers(h http.Header) http.Header {
+	h = spec.Redaction.redactHeaders(h)
+	if spec.IncludeCredentials {
+		return h
+	}
+	return removeHeaders(h, responseCredentialHeaders)
+}
 
-			return h(ctx, rw, req)
+// body returns the text logged for the response body captured by the given writer.
+func (spec *LogResponseSpecification) body(lrw *loggingResponseWriter) string {
+	if lrw.length == 0 {
+		return ""
+	}
+	if isBinary(lrw.Header().Get("Content-Type"), lrw.body) {
+		if spec.BinaryBodies != BinaryBodyHex {
+			return fmt.Sprintf("[binary body, %d bytes]", lrw.length)
+		}
+		summary := lrw.body
+		if len(summary) > hexSummarySize {
+			summary = summary[:hexSummarySize]
 		}
+		return fmt.Sprintf("[binary body, %d bytes] %s", lrw.length, hex.EncodeToString(summary))
 	}
+	body := spec.Redaction.redactBody(lrw.body)
+	if lrw.length > len(lrw.body) {
+		body += fmt.Sprintf("... [truncated, %d bytes total]", lrw.length)
+	}
+	return body
 }
 
-// Recover is a middleware that recovers panics and returns an internal error response.
-func Recover() goa.Middleware {
-	return func(h goa.Handler) goa.Handler {
-		return func(ctx context.Context, rw http.ResponseWriter, req *http.Request) (err error) {
-			defer func() {
-				if r := recover(); r != nil {
-					if ctx != nil {
-						switch x := r.(type) {
-						case string:
-							err = fmt.Errorf("panic: %s", x)
-						case error:
-							err = x
-						default:
-							err = errors.New("unknown panic")
-						}
-						const size = 64 << 10 // 64KB
-						buf := make([]byte, size)
-						buf = buf[:runtime.Stack(buf, false)]
-						lines := strings.Split(string(buf), "\n")
-						stack := lines[3:]
-						status := http.StatusInternalServerError
-						var message string
-						reqID := ctx.Value(ReqIDKey)
-						if reqID != nil {
-							message = fmt.Sprintf(
-								"%s\nRefer to the following token when contacting support: %s",
-								http.StatusText(status),
-								reqID)
-						}
-						goa.Error(ctx, "PANIC", "error", err, "stack", strings.Join(stack, "\n"))
-
-						// note we must respond or else a 500 with "unhandled request" is the
-						// default response.
-						if message == "" {
-							// without the logger and/or request id (from middleware) we can
-							// only return the full error message for reference purposes. it
-							// is unlikely to make sense to the caller unless they understand
-							// the source code.
-							message = err.Error()
-						}
-						rw.WriteHeader(status)
-						rw.Write([]byte(message))
-					}
-				}
-			}()
-			return h(ctx, rw, req)
+// isBinary returns true if the given content type, or the content type detected from the body
+// if empty, denotes binary content.
+func isBinary(contentType string, body []byte) bool {
+	if contentType == "" {
+		contentType = http.DetectContentType(body)
+	}
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	if strings.HasPrefix(mediaType, "text/") {
+		return false
+	}
+	for _, s := range []string{"json", "xml", "javascript", "x-www-form-urlencoded", "yaml"} {
+		if strings.Contains(mediaType, s) {
+			return false
 		}
 	}
+	return true
+}
+
+// RequestID is a middleware that injects a request ID into the context of each request.
+// Retrieve it using ctx.Value(ReqIDKey). If the incoming request has a RequestIDHeader header then
+// that value is used else a random value is generated. See RequestIDMiddleware to customize the
+// header, the ID generator and the validation of incoming IDs.
+func RequestID() goa.Middleware {
+	return RequestIDMiddleware(&RequestIDSpecification{})
+}
+
+// Recover is a middleware that recovers panics and returns an internal error response.
+// See RecoverMiddleware to report panics to error trackers.
+func Recover() goa.Middleware {
+	return RecoverMiddleware(&RecoverSpecification{})
 }
 
 // Timeout sets a global timeout for all controller actions.
@@ -226,20 +377,15 @@ func Recover() goa.Middleware {
 // 	}
 //
 // Controller actions can check if a timeout is set by calling the context Deadline method.
+//
+// See TimeoutMiddleware to enforce the deadline on handlers that do not check the context.
 func Timeout(timeout time.Duration) goa.Middleware {
-	return func(h goa.Handler) goa.Handler {
-		return func(ctx context.Context, rw http.ResponseWriter, req *http.Request) (err error) {
-			// We discard the cancel function because the goa handler already takes
-			// care of canceling on completion.
-			nctx, _ := context.WithTimeout(ctx, timeout)
-			return h(nctx, rw, req)
-		}
-	}
+	return TimeoutMiddleware(&TimeoutSpecification{Timeout: timeout})
 }
 
 // RequireHeader requires a request header to match a value pattern. If the
 // header is missing or does not match then the failureStatus is the response
-// (e.g. http.StatusUnauthorized). If pathPattern is nil then any path is
+// (e.g. http.StatusUnauthorized) with a body rendered by WriteError. If pathPattern is nil then any path is
 // included. If requiredHeaderValue is nil then any value is accepted so long as
 // the header is non-empty.
 func RequireHeader(
@@ -263,8 +409,7 @@ func RequireHeader(
 				if matched {
 					err = h(ctx, rw, req)
 				} else {
-					resp := goa.Response(ctx)
-					err = resp.Send(ctx, failureStatus, http.StatusText(failureStatus))
+					err = WriteError(ctx, responseWriter(ctx, rw), req, failureStatus, "")
 				}
 			} else {
 				err = h(ctx, rw, req)
@@ -255,9 +255,82 @@ var _ = Describe("LogResponse", func() {
 		Ω(lg(ctx, rw, req)).ShouldNot(HaveOccurred())
 		Ω(logger.InfoEntries).Should(HaveLen(1))
 
-		Ω(logger.InfoEntries[0].Data).Should(HaveLen(2))
-		Ω(logger.InfoEntries[0].Data[0]).Should(Equal("body"))
-		Ω(logger.InfoEntries[0].Data[1]).Should(Equal(responseText))
+		Ω(logger.InfoEntries[0].Data).Should(HaveLen(6))
+		Ω(logger.InfoEntries[0].Data[0]).Should(Equal("status"))
+		Ω(logger.InfoEntries[0].Data[1]).Should(Equal(200))
+		Ω(logger.InfoEntries[0].Data[4]).Should(Equal("body"))
+		Ω(logger.InfoEntries[0].Data[5]).Should(Equal(responseText))
+	})
+
+	It("logs streamed responses once", func() {
+		h := func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+			for i := 0; i < 3; i++ {
+				goa.Response(ctx).Write([]byte("chunk"))
+			}
+			return nil
+		}
+		lg := middleware.LogResponse()(h)
+		Ω(lg(ctx, rw, req)).ShouldNot(HaveOccurred())
+		Ω(logger.InfoEntries).Should(HaveLen(1))
+		Ω(logger.InfoEntries[0].Data[1]).Should(Equal(200))
+		Ω(logger.InfoEntries[0].Data[5]).Should(Equal("chunkchunkchunk"))
+	})
+
+	It("truncates long bodies", func() {
+		h := func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+			goa.Response(ctx).Write([]byte(responseText))
+			return nil
+		}
+		lg := middleware.LogResponseMiddleware(&middleware.LogResponseSpecification{MaxBodySize: 4})(h)
+		Ω(lg(ctx, rw, req)).ShouldNot(HaveOccurred())
+		Ω(logger.InfoEntries[0].Data[5]).Should(Equal(fmt.Sprintf("some... [truncated, %d bytes total]", len(responseText))))
+		Ω(string(rw.(*testResponseWriter).Body)).Should(Equal(responseText))
+	})
+
+	Context("with credential headers", func() {
+		h := func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+			goa.Response(ctx).Header().Set("Set-Cookie", "session=s3cr3t")
+			goa.Response(ctx).Header().Set("Authorization", "Bearer s3cr3t")
+			goa.Response(ctx).Header().Set("Content-Type", "text/plain")
+			goa.Response(ctx).WriteHeader(200)
+			return nil
+		}
+
+		It("does not log them by default", func() {
+			lg := middleware.LogResponse()(h)
+			Ω(lg(ctx, rw, req)).ShouldNot(HaveOccurred())
+			Ω(logger.InfoEntries[0].Data[3]).Should(Equal(http.Header{"Content-Type": {"text/plain"}}))
+		})
+
+		It("logs them when requested", func() {
+			spec := &middleware.LogResponseSpecification{IncludeCredentials: true}
+			lg := middleware.LogResponseMiddleware(spec)(h)
+			Ω(lg(ctx, rw, req)).ShouldNot(HaveOccurred())
+			headers := logger.InfoEntries[0].Data[3].(http.Header)
+			Ω(headers.Get("Set-Cookie")).Should(Equal("session=s3cr3t"))
+			Ω(headers.Get("Authorization")).Should(Equal("Bearer s3cr3t"))
+		})
+	})
+
+	Context("with a binary response", func() {
+		h := func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+			rw.(*testResponseWriter).ParentHeader = http.Header{"Content-Type": {"image/png"}}
+			goa.Response(ctx).Write([]byte{0x89, 0x50, 0x4e, 0x47})
+			return nil
+		}
+
+		It("skips the body", func() {
+			lg := middleware.LogResponse()(h)
+			Ω(lg(ctx, rw, req)).ShouldNot(HaveOccurred())
+			Ω(logger.InfoEntries[0].Data[5]).Should(Equal("[binary body, 4 bytes]"))
+		})
+
+		It("logs a hex summary", func() {
+			spec := &middleware.LogResponseSpecification{BinaryBodies: middleware.BinaryBodyHex}
+			lg := middleware.LogResponseMiddleware(spec)(h)
+			Ω(lg(ctx, rw, req)).ShouldNot(HaveOccurred())
+			Ω(logger.InfoEntries[0].Data[5]).Should(Equal("[binary body, 4 bytes] 89504e47"))
+		})
 	})
 })
 
@@ -328,6 +401,21 @@ var _ = Describe("Timeout", func() {
 		_, ok := newCtx.Deadline()
 		Ω(ok).Should(BeTrue())
 	})
+
+	It("leaves the response to the handler when the deadline expires", func() {
+		req, err := http.NewRequest("GET", "/goo", nil)
+		Ω(err).ShouldNot(HaveOccurred())
+		rw := new(testResponseWriter)
+		ctx := newContext(newService(nil), rw, req, nil)
+		h := func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		err = middleware.Timeout(time.Millisecond)(h)(ctx, rw, req)
+		Ω(err).Should(Equal(context.DeadlineExceeded))
+		Ω(goa.Response(ctx).Written()).Should(BeFalse())
+		Ω(rw.Body).Should(BeEmpty())
+	})
 })
 
 var _ = Describe("RequireHeader", func() {
@@ -451,6 +539,9 @@ type testResponseWriter struct {
 }
 
 func (t *testResponseWriter) Header() http.Header {
+	if t.ParentHeader == nil {
+		t.ParentHeader = make(http.Header)
+	}
 	return t.ParentHeader
 }
 
@@ -0,0 +1,181 @@
+package middleware
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/goadesign/goa"
+
+	"golang.org/x/net/context"
+)
+
+// ErrorFormat enumerates the formats of the error responses written by the middlewares.
+type ErrorFormat int
+
+const (
+	// ErrorFormatJSON renders errors as RFC 7807 "application/problem+json" documents.
+	ErrorFormatJSON ErrorFormat = iota
+	// ErrorFormatXML renders errors as RFC 7807 "application/problem+xml" documents.
+	ErrorFormatXML
+	// ErrorFormatText renders errors as plain text.
+	ErrorFormatText
+)
+
+// Problem is the body of the error responses written by the middlewares, see RFC 7807.
+type Problem struct {
+	XMLName xml.Name `json:"-" xml:"urn:ietf:rfc:7807 problem"`
+	// Type is a URI reference that identifies the problem type.
+	Type string `json:"type" xml:"type"`
+	// Title is the short summary of the problem type, the HTTP status text.
+	Title string `json:"title" xml:"title"`
+	// Status is the HTTP status code.
+	Status int `json:"status" xml:"status"`
+	// Detail is the explanation specific to this occurrence of the problem.
+	Detail string `json:"detail,omitempty" xml:"detail,omitempty"`
+	// Instance is the path of the request that caused the problem.
+	Instance string `json:"instance,omitempty" xml:"instance,omitempty"`
+	// RequestID is the request ID set by the RequestID middleware if any.
+	RequestID string `json:"request_id,omitempty" xml:"request_id,omitempty"`
+}
+
+// NewProblem returns the problem describing an error response with the given status and
+// detail for the given request. req may be nil.
+func NewProblem(ctx context.Context, req *http.Request, status int, detail string) *Problem {
+	p := &Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	}
+	if req != nil && req.URL != nil {
+		p.Instance = req.URL.Path
+	}
+	if reqID := ctx.Value(ReqIDKey); reqID != nil {
+		p.RequestID = fmt.Sprintf("%v", reqID)
+	}
+	return p
+}
+
+// WriteError writes an error response with the given status and detail to rw. The format of the
+// response body is negotiated using the request Accept header, see NegotiateErrorFormat. req may
+// be nil in which case the response uses the JSON format. Pass goa.Response(ctx) as rw for the
+// goa response data to record the response status and length.
+func WriteError(ctx context.Context, rw http.ResponseWriter, req *http.Request, status int, detail string) error {
+	var accept string
+	if req != nil {
+		accept = req.Header.Get("Accept")
+	}
+	return NewProblem(ctx, req, status, detail).Write(ctx, rw, NegotiateErrorFormat(accept))
+}
+
+// Write writes the problem to rw using the given format.
+func (p *Problem) Write(ctx context.Context, rw http.ResponseWriter, format ErrorFormat) error {
+	var (
+		body []byte
+		ct   string
+		err  error
+	)
+	switch format {
+	case ErrorFormatXML:
+		ct = "application/problem+xml"
+		body, err = xml.Marshal(p)
+	case ErrorFormatText:
+		ct = "text/plain; charset=utf-8"
+		body = []byte(p.String())
+	default:
+		ct = "application/problem+json"
+		body, err = json.Marshal(p)
+	}
+	if err != nil {
+		return err
+	}
+	rw.Header().Set("Content-Type", ct)
+	rw.Header().Set("X-Content-Type-Options", "nosniff")
+	rw.WriteHeader(p.Status)
+	_, err = rw.Write(body)
+	return err
+}
+
+// responseWriter returns the goa response data of ctx if there is one so that the middlewares
+// writing error responses record the status and length, rw otherwise.
+func responseWriter(ctx context.Context, rw http.ResponseWriter) http.ResponseWriter {
+	if resp := goa.Response(ctx); resp != nil {
+		return resp
+	}
+	return rw
+}
+
+// String returns the plain text representation of the problem.
+func (p *Problem) String() string {
+	lines := []string{p.Title}
+	if p.Detail != "" {
+		lines = append(lines, p.Detail)
+	}
+	if p.RequestID != "" {
+		lines = append(lines, "Refer to the following token when contacting support: "+p.RequestID)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// NegotiateErrorFormat returns the error format that best matches the given Accept header
+// value. JSON is used when the header is empty or accepts no supported format.
+func NegotiateErrorFormat(accept string) ErrorFormat {
+	if accept == "" {
+		return ErrorFormatJSON
+	}
+	var ranges []*mediaRange
+	for _, r := range strings.Split(accept, ",") {
+		if mr := parseMediaRange(r); mr != nil && mr.q > 0 {
+			ranges = append(ranges, mr)
+		}
+	}
+	sort.Stable(byQuality(ranges))
+	for _, mr := range ranges {
+		switch mr.typ {
+		case "application/problem+json", "application/json", "application/*", "*/*":
+			return ErrorFormatJSON
+		case "application/problem+xml", "application/xml", "text/xml":
+			return ErrorFormatXML
+		case "text/plain", "text/*":
+			return ErrorFormatText
+		}
+	}
+	return ErrorFormatJSON
+}
+
+// mediaRange is a media range of an Accept header with its quality.
+type mediaRange struct {
+	typ string
+	q   float64
+}
+
+// parseMediaRange parses a single media range of an Accept header, e.g. "text/plain;q=0.5".
+func parseMediaRange(s string) *mediaRange {
+	parts := strings.Split(s, ";")
+	typ := strings.ToLower(strings.TrimSpace(parts[0]))
+	if typ == "" {
+		return nil
+	}
+	mr := &mediaRange{typ: typ, q: 1}
+	for _, param := range parts[1:] {
+		kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		if len(kv) == 2 && strings.ToLower(kv[0]) == "q" {
+			if q, err := strconv.ParseFloat(kv[1], 64); err == nil {
+				mr.q = q
+			}
+		}
+	}
+	return mr
+}
+
+// byQuality sorts media ranges by decreasing quality.
+type byQuality []*mediaRange
+
+func (b byQuality) Len() int           { return len(b) }
+func (b byQuality) Less(i, j int) bool { return b[i].q > b[j].q }
+func (b byQuality) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
@@ -0,0 +1,114 @@
+package middleware_test
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/goadesign/middleware"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("NegotiateErrorFormat", func() {
+	It("picks the format with the highest quality", func() {
+		Ω(middleware.NegotiateErrorFormat("")).Should(Equal(middleware.ErrorFormatJSON))
+		Ω(middleware.NegotiateErrorFormat("application/problem+json")).Should(Equal(middleware.ErrorFormatJSON))
+		Ω(middleware.NegotiateErrorFormat("text/xml")).Should(Equal(middleware.ErrorFormatXML))
+		Ω(middleware.NegotiateErrorFormat("application/json;q=0.5, text/plain")).Should(Equal(middleware.ErrorFormatText))
+		Ω(middleware.NegotiateErrorFormat("text/html, application/xml;q=0.9, */*;q=0.8")).Should(Equal(middleware.ErrorFormatXML))
+		Ω(middleware.NegotiateErrorFormat("text/plain;q=0, image/png")).Should(Equal(middleware.ErrorFormatJSON))
+	})
+})
+
+var _ = Describe("WriteError", func() {
+	var req *http.Request
+	var rw *testResponseWriter
+	var ctx context.Context
+
+	BeforeEach(func() {
+		var err error
+		req, err = http.NewRequest("GET", "/goo", nil)
+		Ω(err).ShouldNot(HaveOccurred())
+		rw = new(testResponseWriter)
+		ctx = newContext(newService(nil), rw, req, nil)
+		ctx = context.WithValue(ctx, middleware.ReqIDKey, "req-1")
+	})
+
+	It("renders problem+json documents", func() {
+		Ω(middleware.WriteError(ctx, rw, req, 403, "nope")).ShouldNot(HaveOccurred())
+		Ω(rw.Status).Should(Equal(403))
+		Ω(rw.Header().Get("Content-Type")).Should(Equal("application/problem+json"))
+		var p middleware.Problem
+		Ω(json.Unmarshal(rw.Body, &p)).ShouldNot(HaveOccurred())
+		Ω(p.Type).Should(Equal("about:blank"))
+		Ω(p.Title).Should(Equal("Forbidden"))
+		Ω(p.Status).Should(Equal(403))
+		Ω(p.Detail).Should(Equal("nope"))
+		Ω(p.Instance).Should(Equal("/goo"))
+		Ω(p.RequestID).Should(Equal("req-1"))
+	})
+
+	It("renders problem+xml documents", func() {
+		req.Header.Set("Accept", "application/xml")
+		Ω(middleware.WriteError(ctx, rw, req, 403, "nope")).ShouldNot(HaveOccurred())
+		Ω(rw.Header().Get("Content-Type")).Should(Equal("application/problem+xml"))
+		var p middleware.Problem
+		Ω(xml.Unmarshal(rw.Body, &p)).ShouldNot(HaveOccurred())
+		Ω(p.XMLName.Space).Should(Equal("urn:ietf:rfc:7807"))
+		Ω(p.Status).Should(Equal(403))
+		Ω(p.RequestID).Should(Equal("req-1"))
+	})
+
+	It("renders plain text", func() {
+		req.Header.Set("Accept", "text/plain")
+		Ω(middleware.WriteError(ctx, rw, req, 403, "nope")).ShouldNot(HaveOccurred())
+		Ω(rw.Header().Get("Content-Type")).Should(HavePrefix("text/plain"))
+		Ω(string(rw.Body)).Should(Equal("Forbidden\nnope\nRefer to the following token when contacting support: req-1"))
+	})
+
+	It("writes to the given writer", func() {
+		rec := new(testResponseWriter)
+		Ω(middleware.WriteError(ctx, rec, req, 403, "nope")).ShouldNot(HaveOccurred())
+		Ω(rec.Status).Should(Equal(403))
+		Ω(rec.Body).ShouldNot(BeEmpty())
+		Ω(rw.Status).Should(Equal(0))
+		Ω(rw.Body).Should(BeEmpty())
+	})
+
+	It("is used by Recover, RequireHeader and Timeout", func() {
+		req.Header.Set("Accept", "application/json")
+		var p middleware.Problem
+
+		boom := func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+			panic("boom")
+		}
+		middleware.Recover()(boom)(ctx, rw, req)
+		Ω(json.Unmarshal(rw.Body, &p)).ShouldNot(HaveOccurred())
+		Ω(p.Status).Should(Equal(500))
+		Ω(p.RequestID).Should(Equal("req-1"))
+
+		rw = new(testResponseWriter)
+		ctx = newContext(newService(nil), rw, req, nil)
+		noop := func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+			return nil
+		}
+		middleware.RequireHeader(nil, "X-Token", nil, http.StatusUnauthorized)(noop)(ctx, rw, req)
+		Ω(json.Unmarshal(rw.Body, &p)).ShouldNot(HaveOccurred())
+		Ω(p.Status).Should(Equal(401))
+
+		rw = new(testResponseWriter)
+		ctx = newContext(newService(nil), rw, req, nil)
+		slow := func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		spec := &middleware.TimeoutSpecification{Timeout: time.Millisecond, Enforce: true}
+		Ω(middleware.TimeoutMiddleware(spec)(slow)(ctx, rw, req)).ShouldNot(HaveOccurred())
+		Ω(json.Unmarshal(rw.Body, &p)).ShouldNot(HaveOccurred())
+		Ω(p.Status).Should(Equal(503))
+	})
+})
@@ -0,0 +1,482 @@
+package middleware
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goadesign/goa"
+
+	"golang.org/x/net/context"
+)
+
+// RecoverSpecification describes how the Recover middleware reports panics.
+type RecoverSpecification struct {
+	// Reporters are called with each recovered panic, e.g. to forward it to an error tracker.
+	Reporters []PanicReporter
+	// Redaction describes the sensitive request headers removed from the panic events.
+	// Optional, headers other than the credential headers are reported as is if nil.
+	Redaction *Redaction
+	// IncludeCredentials is a flag that determines whether the Authorization,
+	// Proxy-Authorization and Cookie request headers are included in the panic events. They
+	// are removed by default as reporters usually forward the events to third party services.
+	IncludeCredentials bool
+	// Repanic is a flag that determines whether the middleware panics again with the same
+	// value after logging and reporting the panic instead of writing an error response. This
+	// lets the HTTP server abort the connection as done with http.ErrAbortHandler.
+	Repanic bool
+}
+
+// PanicReporter is called by the Recover middleware with each recovered panic.
+type PanicReporter func(ctx context.Context, e *PanicEvent)
+
+// PanicEvent describes a panic recovered by the Recover middleware.
+type PanicEvent struct {
+	// Time is the time the panic was recovered.
+	Time time.Time `json:"time"`
+	// Value is the value given to panic.
+	Value interface{} `json:"-"`
+	// Message is the string representation of Value.
+	Message string `json:"message"`
+	// Frames lists the stack frames of the panicking goroutine, innermost first.
+	Frames []*StackFrame `json:"frames"`
+	// Method is the request HTTP method.
+	Method string `json:"method,omitempty"`
+	// Path is the request path.
+	Path string `json:"path,omitempty"`
+	// Header contains the request headers.
+	Header http.Header `json:"header,omitempty"`
+	// RequestID is the request ID set by the RequestID middleware if any.
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// StackFrame is a single frame of a panic stack trace.
+type StackFrame struct {
+	// Function is the fully qualified name of the function.
+	Function string `json:"function"`
+	// File is the path to the source file.
+	File string `json:"file"`
+	// Line is the line number in the source file.
+	Line int `json:"line"`
+}
+
+// WriterReporter returns a PanicReporter that writes each panic event as a JSON line to w, for
+// example a log file.
+func WriterReporter(w io.Writer) PanicReporter {
+	var mu sync.Mutex
+	return func(ctx context.Context, e *PanicEvent) {
+		b, err := json.Marshal(e)
+		if err != nil {
+			goa.Error(ctx, "failed to encode panic event", "err", err)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		w.Write(append(b, '\n'))
+	}
+}
+
+// MemoryReporter records the panic events in memory, it is mostly useful in tests. Use its
+// Report method as PanicReporter.
+type MemoryReporter struct {
+	mu     sync.Mutex
+	events []*PanicEvent
+}
+
+// Report records the given panic event.
+func (r *MemoryReporter) Report(ctx context.Context, e *PanicEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, e)
+}
+
+// Events returns the recorded panic events.
+func (r *MemoryReporter) Events() []*PanicEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*PanicEvent(nil), r.events...)
+}
+
+// RecoverMiddleware is a middleware that recovers panics, logs them, calls the specification
+// reporters and returns an internal error response rendered with WriteError. Panics with
+// http.ErrAbortHandler are not recovered so that the HTTP server aborts the connection silently.
+// If the handler already wrote the response status or body when it panicked the connection is
+// aborted instead: it is closed if it can be hijacked (HTTP/1.x) else the middleware panics with
+// http.ErrAbortHandler which causes the HTTP server to close the connection or reset the HTTP/2
+// stream. Panics raised after the handler hijacked the connection are only logged and reported.
+func RecoverMiddleware(spec *RecoverSpecification) goa.Middleware {
+	return func(h goa.Handler) goa.Handler {
+		return func(ctx context.Context, rw http.ResponseWriter, req *http.Request) (err error) {
+			// chain a writer that records whether the response was committed.
+			var cw *commitWriter
+			if ctx != nil {
+				if resp := goa.Response(ctx); resp != nil {
+					var w http.ResponseWriter
+					cw, w = newCommitWriter(resp.SwitchWriter(nil))
+					resp.SwitchWriter(w)
+				}
+			}
+			if cw == nil {
+				cw, rw = newCommitWriter(rw)
+			}
+			defer func() {
+				if r := recover(); r != nil {
+					pcs, stack := panicStack()
+					if hp, ok := r.(*handlerPanic); ok {
+						// the panic happened in a goroutine started by another middleware.
+						r, pcs, stack = hp.value, hp.pcs, hp.stack
+					}
+					if r == http.ErrAbortHandler {
+						panic(r)
+					}
+					if ctx != nil {
+						switch x := r.(type) {
+						case string:
+							err = fmt.Errorf("panic: %s", x)
+						case error:
+							err = x
+						default:
+							err = errors.New("unknown panic")
+						}
+						goa.Error(ctx, "PANIC", "error", err, "stack", stack)
+						if len(spec.Reporters) > 0 {
+							e := spec.newPanicEvent(ctx, req, r, pcs)
+							for _, report := range spec.Reporters {
+								report(ctx, e)
+							}
+						}
+						if spec.Repanic {
+							panic(r)
+						}
+
+						if cw.hijacked {
+							// the handler took over the connection, nothing can be written to
+							// it anymore.
+							return
+						}
+						if cw.committed {
+							// the handler already started writing the response, appending an
+							// error body would corrupt it so abort the connection instead.
+							cw.abort(ctx)
+							return
+						}
+
+						// note we must respond or else a 500 with "unhandled request" is the
+						// default response.
+						var detail string
+						if ctx.Value(ReqIDKey) == nil {
+							// without the logger and/or request id (from middleware) we can
+							// only return the full error message for reference purposes. it
+							// is unlikely to make sense to the caller unless they understand
+							// the source code.
+							detail = err.Error()
+						}
+						WriteError(ctx, responseWriter(ctx, rw), req, http.StatusInternalServerError, detail)
+					}
+				}
+			}()
+			return h(ctx, rw, req)
+		}
+	}
+}
+
+// commitWriter is a response writer that records whether the response status or body was
+// written or the connection hijacked.
+type commitWriter struct {
+	http.ResponseWriter
+	committed bool
+	hijacked  bool
+}
+
+// Optional interfaces of the response writers wrapped by commitWriter.
+const (
+	canFlush = 1 << iota
+	canHijack
+	canCloseNotify
+	canPush
+)
+
+// newCommitWriter returns a commitWriter wrapping w and the response writer to use in place of
+// w. The returned writer implements exactly the interfaces among http.Flusher, http.Hijacker,
+// http.CloseNotifier and http.Pusher that w implements so that the handlers can still detect
+// them.
+func newCommitWriter(w http.ResponseWriter) (*commitWriter, http.ResponseWriter) {
+	cw := &commitWriter{ResponseWriter: w}
+	var kind int
+	if _, ok := w.(http.Flusher); ok {
+		kind |= canFlush
+	}
+	if _, ok := w.(http.Hijacker); ok {
+		kind |= canHijack
+	}
+	if _, ok := w.(http.CloseNotifier); ok {
+		kind |= canCloseNotify
+	}
+	if _, ok := w.(http.Pusher); ok {
+		kind |= canPush
+	}
+	f, h, c, p := flushFunc(cw.flush), hijackFunc(cw.hijack), closeNotifyFunc(cw.closeNotify), pushFunc(cw.push)
+	switch kind {
+	case canFlush:
+		return cw, struct {
+			*commitWriter
+			flushFunc
+		}{cw, f}
+	case canHijack:
+		return cw, struct {
+			*commitWriter
+			hijackFunc
+		}{cw, h}
+	case canFlush | canHijack:
+		return cw, struct {
+			*commitWriter
+			flushFunc
+			hijackFunc
+		}{cw, f, h}
+	case canCloseNotify:
+		return cw, struct {
+			*commitWriter
+			closeNotifyFunc
+		}{cw, c}
+	case canFlush | canCloseNotify:
+		return cw, struct {
+			*commitWriter
+			flushFunc
+			closeNotifyFunc
+		}{cw, f, c}
+	case canHijack | canCloseNotify:
+		return cw, struct {
+			*commitWriter
+			hijackFunc
+			closeNotifyFunc
+		}{cw, h, c}
+	case canFlush | canHijack | canCloseNotify:
+		return cw, struct {
+			*commitWriter
+			flushFunc
+			hijackFunc
+			closeNotifyFunc
+		}{cw, f, h, c}
+	case canPush:
+		return cw, struct {
+			*commitWriter
+			pushFunc
+		}{cw, p}
+	case canFlush | canPush:
+		return cw, struct {
+			*commitWriter
+			flushFunc
+			pushFunc
+		}{cw, f, p}
+	case canHijack | canPush:
+		return cw, struct {
+			*commitWriter
+			hijackFunc
+			pushFunc
+		}{cw, h, p}
+	case canFlush | canHijack | canPush:
+		return cw, struct {
+			*commitWriter
+			flushFunc
+			hijackFunc
+			pushFunc
+		}{cw, f, h, p}
+	case canCloseNotify | canPush:
+		return cw, struct {
+			*commitWriter
+			closeNotifyFunc
+			pushFunc
+		}{cw, c, p}
+	case canFlush | canCloseNotify | canPush:
+		return cw, struct {
+			*commitWriter
+			flushFunc
+			closeNotifyFunc
+			pushFunc
+		}{cw, f, c, p}
+	case canHijack | canCloseNotify | canPush:
+		return cw, struct {
+			*commitWriter
+			hijackFunc
+			closeNotifyFunc
+			pushFunc
+		}{cw, h, c, p}
+	case canFlush | canHijack | canCloseNotify | canPush:
+		return cw, struct {
+			*commitWriter
+			flushFunc
+			hijackFunc
+			closeNotifyFunc
+			pushFunc
+		}{cw, f, h, c, p}
+	}
+	return cw, cw
+}
+
+// WriteHeader records the response as committed and writes the status.
+func (cw *commitWriter) WriteHeader(status int) {
+	cw.committed = true
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+// Write records the response as committed and writes the data.
+func (cw *commitWriter) Write(buf []byte) (int, error) {
+	cw.committed = true
+	return cw.ResponseWriter.Write(buf)
+}
+
+// flush records the response as committed and sends any buffered data to the client.
+func (cw *commitWriter) flush() {
+	cw.committed = true
+	cw.ResponseWriter.(http.Flusher).Flush()
+}
+
+// hijack lets the caller take over the connection and records it if successful.
+func (cw *commitWriter) hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, buf, err := cw.ResponseWriter.(http.Hijacker).Hijack()
+	if err == nil {
+		cw.committed = true
+		cw.hijacked = true
+	}
+	return conn, buf, err
+}
+
+// closeNotify returns the channel of the underlying response writer that reports when the client
+// goes away.
+func (cw *commitWriter) closeNotify() <-chan bool {
+	return cw.ResponseWriter.(http.CloseNotifier).CloseNotify()
+}
+
+// push initiates an HTTP/2 server push with the underlying response writer.
+func (cw *commitWriter) push(target string, opts *http.PushOptions) error {
+	return cw.ResponseWriter.(http.Pusher).Push(target, opts)
+}
+
+// flushFunc, hijackFunc, closeNotifyFunc and pushFunc implement the optional response writer
+// interfaces with functions so that they can be combined in the writers returned by
+// newCommitWriter.
+type (
+	flushFunc       func()
+	hijackFunc      func() (net.Conn, *bufio.ReadWriter, error)
+	closeNotifyFunc func() <-chan bool
+	pushFunc        func(string, *http.PushOptions) error
+)
+
+func (f flushFunc) Flush()                                          { f() }
+func (f hijackFunc) Hijack() (net.Conn, *bufio.ReadWriter, error)   { return f() }
+func (f closeNotifyFunc) CloseNotify() <-chan bool                  { return f() }
+func (f pushFunc) Push(target string, opts *http.PushOptions) error { return f(target, opts) }
+
+// abort aborts the connection of a response that was already committed. It closes the connection
+// if it can be hijacked and panics with http.ErrAbortHandler otherwise.
+func (cw *commitWriter) abort(ctx context.Context) {
+	if h, ok := cw.ResponseWriter.(http.Hijacker); ok {
+		if conn, _, err := h.Hijack(); err == nil {
+			goa.Error(ctx, "response already committed, closing connection", "abort", "hijack")
+			conn.Close()
+			return
+		}
+	}
+	goa.Error(ctx, "response already committed, aborting handler", "abort", "panic")
+	panic(http.ErrAbortHandler)
+}
+
+// newPanicEvent builds the event describing the given recovered panic value, pcs are the program
+// counters of the panicking goroutine as returned by panicStack.
+func (spec *RecoverSpecification) newPanicEvent(ctx context.Context, req *http.Request, r interface{}, pcs []uintptr) *PanicEvent {
+	e := &PanicEvent{
+		Time:    time.Now(),
+		Value:   r,
+		Message: fmt.Sprint(r),
+		Frames:  panicFrames(pcs),
+	}
+	if req != nil {
+		e.Method = req.Method
+		e.Path = req.URL.Path
+		e.Header = spec.headers(req.Header)
+	}
+	if reqID := ctx.Value(ReqIDKey); reqID != nil {
+		e.RequestID = fmt.Sprintf("%v", reqID)
+	}
+	return e
+}
+
+// credentialHeaders lists the request headers removed from the panic events unless
+// IncludeCredentials is set.
+var credentialHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie"}
+
+// headers returns the request headers reported in the panic events.
+func (spec *RecoverSpecification) headers(h http.Header) http.Header {
+	h = spec.Redaction.redactHeaders(h)
+	if spec.IncludeCredentials {
+		return h
+	}
+	return removeHeaders(h, credentialHeaders)
+}
+
+// handlerPanic wraps a panic value recovered in a goroutine running the handler on behalf of a
+// middleware, e.g. TimeoutMiddleware, together with the stack of that goroutine. The middleware
+// panics again with it in the request goroutine so that the Recover middleware reports the code
+// that panicked rather than the middleware.
+type handlerPanic struct {
+	value interface{}
+	pcs   []uintptr
+	stack string
+}
+
+// newHandlerPanic returns the handlerPanic for the given value. It must be called by the deferred
+// function that recovered the panic.
+func newHandlerPanic(value interface{}) *handlerPanic {
+	pcs, stack := panicStack()
+	return &handlerPanic{value: value, pcs: pcs, stack: stack}
+}
+
+// String returns the panic value and stack, it is used by the HTTP server to log panics that are
+// not recovered.
+func (hp *handlerPanic) String() string {
+	return fmt.Sprintf("%v\n%s", hp.value, hp.stack)
+}
+
+// panicStack returns the program counters and the formatted stack of the panicking goroutine. It
+// must be called by the deferred function that recovered the panic, the frames of panicStack and
+// of the deferred function are omitted from the formatted stack.
+func panicStack() ([]uintptr, string) {
+	pcs := make([]uintptr, 64)
+	pcs = pcs[:runtime.Callers(1, pcs)]
+	const size = 64 << 10 // 64KB
+	buf := make([]byte, size)
+	buf = buf[:runtime.Stack(buf, false)]
+	lines := strings.Split(string(buf), "\n")
+	if len(lines) > 5 {
+		lines = lines[5:]
+	}
+	return pcs, strings.Join(lines, "\n")
+}
+
+// panicFrames returns the frames of the panicking goroutine starting with the function that
+// called panic given the program counters returned by panicStack.
+func panicFrames(pcs []uintptr) []*StackFrame {
+	var frames []*StackFrame
+	it := runtime.CallersFrames(pcs)
+	for {
+		f, more := it.Next()
+		if f.Function == "runtime.gopanic" {
+			// Drop the frames of the recovery code.
+			frames = frames[:0]
+		} else {
+			frames = append(frames, &StackFrame{Function: f.Function, File: f.File, Line: f.Line})
+		}
+		if !more {
+			break
+		}
+	}
+	return frames
+}
@@ -0,0 +1,202 @@
+package middleware_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+
+	"golang.org/x/net/context"
+
+	"github.com/goadesign/goa"
+	"github.com/goadesign/middleware"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RecoverMiddleware", func() {
+	var spec *middleware.RecoverSpecification
+	var reporter *middleware.MemoryReporter
+	var req *http.Request
+	var rw *testResponseWriter
+	var ctx context.Context
+
+	BeforeEach(func() {
+		reporter = new(middleware.MemoryReporter)
+		spec = &middleware.RecoverSpecification{Reporters: []middleware.PanicReporter{reporter.Report}}
+		var err error
+		req, err = http.NewRequest("GET", "/goo", nil)
+		Ω(err).ShouldNot(HaveOccurred())
+		req.Header.Set("Authorization", "Bearer secret")
+		req.Header.Set("Accept", "application/json")
+		rw = new(testResponseWriter)
+		ctx = newContext(newService(nil), rw, req, nil)
+		ctx = context.WithValue(ctx, middleware.ReqIDKey, "req-1")
+	})
+
+	boom := func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+		panic("boom")
+	}
+
+	It("reports structured panic events", func() {
+		spec.Redaction = &middleware.Redaction{Headers: []string{"Authorization"}}
+		spec.IncludeCredentials = true
+		err := middleware.RecoverMiddleware(spec)(boom)(ctx, rw, req)
+		Ω(err).Should(HaveOccurred())
+		Ω(rw.Status).Should(Equal(500))
+		events := reporter.Events()
+		Ω(events).Should(HaveLen(1))
+		e := events[0]
+		Ω(e.Value).Should(Equal("boom"))
+		Ω(e.Message).Should(Equal("boom"))
+		Ω(e.Method).Should(Equal("GET"))
+		Ω(e.Path).Should(Equal("/goo"))
+		Ω(e.RequestID).Should(Equal("req-1"))
+		Ω(e.Header.Get("Authorization")).Should(Equal("[REDACTED]"))
+		Ω(e.Header.Get("Accept")).Should(Equal("application/json"))
+		Ω(e.Frames).ShouldNot(BeEmpty())
+		Ω(e.Frames[0].Function).Should(ContainSubstring("middleware_test"))
+		Ω(e.Frames[0].Line).Should(BeNumerically(">", 0))
+	})
+
+	It("removes the credential headers by default", func() {
+		req.Header.Set("Cookie", "session=secret")
+		req.Header.Set("Proxy-Authorization", "Basic secret")
+		middleware.RecoverMiddleware(spec)(boom)(ctx, rw, req)
+		e := reporter.Events()[0]
+		Ω(e.Header).ShouldNot(HaveKey("Authorization"))
+		Ω(e.Header).ShouldNot(HaveKey("Cookie"))
+		Ω(e.Header).ShouldNot(HaveKey("Proxy-Authorization"))
+		Ω(e.Header.Get("Accept")).Should(Equal("application/json"))
+		Ω(req.Header.Get("Authorization")).Should(Equal("Bearer secret"))
+	})
+
+	It("includes the credential headers when requested", func() {
+		spec.IncludeCredentials = true
+		middleware.RecoverMiddleware(spec)(boom)(ctx, rw, req)
+		Ω(reporter.Events()[0].Header.Get("Authorization")).Should(Equal("Bearer secret"))
+	})
+
+	It("writes events as JSON lines", func() {
+		output := new(bytes.Buffer)
+		spec.Reporters = []middleware.PanicReporter{middleware.WriterReporter(output)}
+		middleware.RecoverMiddleware(spec)(boom)(ctx, rw, req)
+		var e map[string]interface{}
+		Ω(json.Unmarshal(output.Bytes(), &e)).ShouldNot(HaveOccurred())
+		Ω(e["message"]).Should(Equal("boom"))
+		Ω(e["request_id"]).Should(Equal("req-1"))
+		Ω(e["frames"]).ShouldNot(BeEmpty())
+	})
+
+	It("panics again after reporting", func() {
+		spec.Repanic = true
+		Ω(func() { middleware.RecoverMiddleware(spec)(boom)(ctx, rw, req) }).Should(Panic())
+		Ω(reporter.Events()).Should(HaveLen(1))
+		Ω(rw.Status).Should(Equal(0))
+	})
+
+	It("does not recover http.ErrAbortHandler", func() {
+		h := func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+			panic(http.ErrAbortHandler)
+		}
+		Ω(func() { middleware.RecoverMiddleware(spec)(h)(ctx, rw, req) }).Should(Panic())
+		Ω(reporter.Events()).Should(BeEmpty())
+	})
+
+	It("preserves the optional interfaces of the response writer", func() {
+		nrw := &notifyPushResponseWriter{closed: make(chan bool)}
+		ctx = newContext(newService(nil), nrw, req, nil)
+		var w http.ResponseWriter
+		h := func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+			w = goa.Response(ctx).ResponseWriter
+			return nil
+		}
+		Ω(middleware.RecoverMiddleware(spec)(h)(ctx, nrw, req)).ShouldNot(HaveOccurred())
+		cn, ok := w.(http.CloseNotifier)
+		Ω(ok).Should(BeTrue())
+		Ω(cn.CloseNotify()).Should(BeIdenticalTo((<-chan bool)(nrw.closed)))
+		p, ok := w.(http.Pusher)
+		Ω(ok).Should(BeTrue())
+		Ω(p.Push("/style.css", nil)).ShouldNot(HaveOccurred())
+		Ω(nrw.pushed).Should(Equal([]string{"/style.css"}))
+		_, ok = w.(http.Hijacker)
+		Ω(ok).Should(BeFalse())
+		_, ok = w.(http.Flusher)
+		Ω(ok).Should(BeFalse())
+	})
+
+	It("leaves hijacked connections alone", func() {
+		hrw := &hijackResponseWriter{}
+		hrw.conn, hrw.peer = net.Pipe()
+		ctx = newContext(newService(nil), hrw, req, nil)
+		h := func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+			conn, _, err := goa.Response(ctx).ResponseWriter.(http.Hijacker).Hijack()
+			Ω(err).ShouldNot(HaveOccurred())
+			go conn.Write([]byte("hijacked"))
+			buf := make([]byte, 8)
+			_, err = io.ReadFull(hrw.peer, buf)
+			Ω(err).ShouldNot(HaveOccurred())
+			panic("boom")
+		}
+		Ω(func() { middleware.RecoverMiddleware(spec)(h)(ctx, hrw, req) }).ShouldNot(Panic())
+		Ω(hrw.Status).Should(Equal(0))
+		Ω(hrw.Body).Should(BeEmpty())
+		Ω(reporter.Events()).Should(HaveLen(1))
+		// the connection is not closed by the middleware.
+		go hrw.conn.Write([]byte("x"))
+		_, err := hrw.peer.Read(make([]byte, 1))
+		Ω(err).ShouldNot(HaveOccurred())
+	})
+
+	Context("with a committed response", func() {
+		streaming := func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+			resp := goa.Response(ctx)
+			resp.WriteHeader(200)
+			resp.Write([]byte("partial"))
+			panic("boom")
+		}
+
+		It("closes hijackable connections", func() {
+			hrw := &hijackResponseWriter{}
+			hrw.conn, hrw.peer = net.Pipe()
+			ctx = newContext(newService(nil), hrw, req, nil)
+			middleware.RecoverMiddleware(spec)(streaming)(ctx, hrw, req)
+			Ω(hrw.Status).Should(Equal(200))
+			Ω(string(hrw.Body)).Should(Equal("partial"))
+			_, err := hrw.peer.Read(make([]byte, 1))
+			Ω(err).Should(Equal(io.EOF))
+			Ω(reporter.Events()).Should(HaveLen(1))
+		})
+
+		It("panics with http.ErrAbortHandler otherwise", func() {
+			Ω(func() { middleware.RecoverMiddleware(spec)(streaming)(ctx, rw, req) }).Should(PanicWith(http.ErrAbortHandler))
+			Ω(string(rw.Body)).Should(Equal("partial"))
+		})
+	})
+})
+
+type hijackResponseWriter struct {
+	testResponseWriter
+	conn, peer net.Conn
+}
+
+func (h *hijackResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return h.conn, bufio.NewReadWriter(bufio.NewReader(h.conn), bufio.NewWriter(h.conn)), nil
+}
+
+type notifyPushResponseWriter struct {
+	testResponseWriter
+	closed chan bool
+	pushed []string
+}
+
+func (n *notifyPushResponseWriter) CloseNotify() <-chan bool {
+	return n.closed
+}
+
+func (n *notifyPushResponseWriter) Push(target string, opts *http.PushOptions) error {
+	n.pushed = append(n.pushed, target)
+	return nil
+}
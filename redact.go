@@ -0,0 +1,226 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// redactionReplacementDefault is the default marker that replaces redacted values.
+const redactionReplacementDefault = "[REDACTED]"
+
+// Redaction describes the sensitive data that the logging middlewares must not log. Redacted
+// values are replaced with the Replacement marker.
+type Redaction struct {
+	// Headers lists the names of the headers whose values are redacted, the names are case
+	// insensitive.
+	Headers []string
+	// Params lists the names of the request params and querystring parameters whose values are
+	// redacted, the names are case insensitive.
+	Params []string
+	// Paths lists the JSON paths of the payload and body fields whose values are redacted.
+	// Paths use dots to separate field names, e.g. "user.password", and "*" to match any field
+	// name. Arrays are traversed transparently so that "users.password" redacts the password
+	// of all the elements of the "users" array.
+	// Logged response bodies that cannot be decoded as JSON, for example because they were
+	// truncated, are replaced with "[redacted: unparseable]" when Paths is not empty.
+	Paths []string
+	// Patterns lists regular expressions matching sensitive data in any logged string, e.g.
+	// card numbers. The matching text is replaced.
+	Patterns []*regexp.Regexp
+	// Replacement is the marker that replaces the redacted values.
+	// Defaults to "[REDACTED]".
+	Replacement string
+}
+
+// replacement returns the marker that replaces redacted values.
+func (r *Redaction) replacement() string {
+	if r.Replacement == "" {
+		return redactionReplacementDefault
+	}
+	return r.Replacement
+}
+
+// redactString replaces the text matching the redaction patterns.
+func (r *Redaction) redactString(s string) string {
+	if r == nil {
+		return s
+	}
+	for _, p := range r.Patterns {
+		s = p.ReplaceAllString(s, r.replacement())
+	}
+	return s
+}
+
+// redactParam returns the value of the param with the given name as it should be logged.
+func (r *Redaction) redactParam(name, value string) string {
+	if r == nil {
+		return value
+	}
+	if containsFold(r.Params, name) {
+		return r.replacement()
+	}
+	return r.redactString(value)
+}
+
+// redactHeaders returns a copy of the given headers with the sensitive values redacted.
+func (r *Redaction) redactHeaders(h http.Header) http.Header {
+	if r == nil {
+		return h
+	}
+	res := make(http.Header, len(h))
+	for k, vals := range h {
+		redacted := make([]string, len(vals))
+		for i, v := range vals {
+			if containsFold(r.Headers, k) {
+				redacted[i] = r.replacement()
+			} else {
+				redacted[i] = r.redactString(v)
+			}
+		}
+		res[k] = redacted
+	}
+	return res
+}
+
+// removeHeaders returns a copy of the given headers without the headers with the given names,
+// the names are case insensitive.
+func removeHeaders(h http.Header, names []string) http.Header {
+	res := make(http.Header, len(h))
+	for k, v := range h {
+		if !containsFold(names, k) {
+			res[k] = v
+		}
+	}
+	return res
+}
+
+// redactURL returns the string representation of the given URL with the sensitive querystring
+// parameter values redacted.
+func (r *Redaction) redactURL(u *url.URL) string {
+	if r == nil || u.RawQuery == "" {
+		return r.redactString(u.String())
+	}
+	res := *u
+	query := u.Query()
+	for k, vals := range query {
+		for i, v := range vals {
+			vals[i] = r.redactParam(k, v)
+		}
+	}
+	res.RawQuery = query.Encode()
+	return r.redactString(res.String())
+}
+
+// redactValue returns a copy of the given JSON value with the fields matching the redaction
+// paths and the strings matching the redaction patterns redacted. path is the path of v.
+func (r *Redaction) redactValue(path []string, v interface{}) interface{} {
+	if r.matchPath(path) {
+		return r.replacement()
+	}
+	switch val := v.(type) {
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(val))
+		for k, e := range val {
+			res[k] = r.redactValue(append(path[:len(path):len(path)], k), e)
+		}
+		return res
+	case []interface{}:
+		res := make([]interface{}, len(val))
+		for i, e := range val {
+			res[i] = r.redactValue(path, e)
+		}
+		return res
+	case string:
+		return r.redactString(val)
+	}
+	return v
+}
+
+// redactPayload returns the given request payload with the sensitive data redacted. Payloads
+// other than generic JSON values are converted to generic values first so that the redaction
+// paths apply.
+func (r *Redaction) redactPayload(payload interface{}) interface{} {
+	if r == nil {
+		return payload
+	}
+	switch payload.(type) {
+	case map[string]interface{}, []interface{}, string, nil:
+	default:
+		b, err := json.Marshal(payload)
+		if err != nil {
+			return payload
+		}
+		var generic interface{}
+		if err := json.Unmarshal(b, &generic); err != nil {
+			return payload
+		}
+		payload = generic
+	}
+	return r.redactValue(nil, payload)
+}
+
+// unparseableBody is the marker logged instead of the bodies that cannot be decoded when
+// redaction paths are set.
+const unparseableBody = "[redacted: unparseable]"
+
+// redactBody returns the given response body as it should be logged. JSON bodies are decoded
+// so that the redaction paths apply, the patterns are applied to all bodies. If redaction paths
+// are set then bodies that cannot be decoded, including JSON bodies truncated to the maximum
+// logged size, are replaced with a marker: the sensitive fields could not be located in them.
+func (r *Redaction) redactBody(body []byte) string {
+	if r == nil {
+		return string(body)
+	}
+	if len(r.Paths) > 0 {
+		dec := json.NewDecoder(bytes.NewReader(body))
+		dec.UseNumber()
+		var v interface{}
+		if err := dec.Decode(&v); err != nil || dec.More() {
+			return unparseableBody
+		}
+		b, err := json.Marshal(r.redactValue(nil, v))
+		if err != nil {
+			return unparseableBody
+		}
+		return string(b)
+	}
+	return r.redactString(string(body))
+}
+
+// matchPath returns true if the given path matches one of the redaction paths.
+func (r *Redaction) matchPath(path []string) bool {
+	if len(path) == 0 {
+		return false
+	}
+	for _, p := range r.Paths {
+		segments := strings.Split(p, ".")
+		if len(segments) != len(path) {
+			continue
+		}
+		match := true
+		for i, s := range segments {
+			if s != "*" && s != path[i] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// containsFold returns true if vals contains val using case insensitive comparison.
+func containsFold(vals []string, val string) bool {
+	for _, v := range vals {
+		if strings.EqualFold(v, val) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,145 @@
+package middleware_test
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"github.com/goadesign/goa"
+	"github.com/goadesign/middleware"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Redaction", func() {
+	var redaction *middleware.Redaction
+	var logger *testLogger
+	var ctx context.Context
+	var req *http.Request
+	var rw *testResponseWriter
+
+	BeforeEach(func() {
+		redaction = &middleware.Redaction{
+			Headers:  []string{"Authorization"},
+			Params:   []string{"token"},
+			Paths:    []string{"password", "cards.number"},
+			Patterns: []*regexp.Regexp{regexp.MustCompile(`\b\d{4}-\d{4}-\d{4}-\d{4}\b`)},
+		}
+		logger = new(testLogger)
+		service := newService(logger)
+		var err error
+		req, err = http.NewRequest("POST", "/goo?token=s3cr3t&page=2", strings.NewReader(`{"password":"pw"}`))
+		Ω(err).ShouldNot(HaveOccurred())
+		req.Header.Set("Authorization", "Bearer s3cr3t")
+		rw = new(testResponseWriter)
+		ctx = newContext(service, rw, req, url.Values{"token": {"s3cr3t"}, "page": {"2"}})
+		goa.Request(ctx).Payload = map[string]interface{}{
+			"user":     "alice",
+			"password": "pw",
+			"cards":    []interface{}{map[string]interface{}{"number": "1", "name": "visa"}},
+			"note":     "paid with 1234-5678-9012-3456",
+		}
+	})
+
+	entry := func(entries []logEntry, msg string) map[interface{}]interface{} {
+		for _, e := range entries {
+			if e.Msg == msg {
+				res := make(map[interface{}]interface{})
+				for i := 0; i+1 < len(e.Data); i += 2 {
+					res[e.Data[i]] = e.Data[i+1]
+				}
+				return res
+			}
+		}
+		return nil
+	}
+
+	It("redacts LogRequest URLs, headers, params and payloads", func() {
+		spec := &middleware.LogSpecification{Verbose: true, LogHeaders: true, Redaction: redaction}
+		h := func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+			return goa.Response(ctx).Send(ctx, 200, "ok")
+		}
+		Ω(middleware.LogRequestMiddleware(spec)(h)(ctx, rw, req)).ShouldNot(HaveOccurred())
+
+		for _, e := range logger.InfoEntries {
+			for _, d := range e.Data {
+				Ω(fmt.Sprint(d)).ShouldNot(ContainSubstring("s3cr3t"))
+			}
+		}
+		Ω(logger.InfoEntries[0].Data[3]).Should(Equal("/goo?page=2&token=%5BREDACTED%5D"))
+		Ω(entry(logger.InfoEntries, "headers")["Authorization"]).Should(Equal("[REDACTED]"))
+		Ω(entry(logger.InfoEntries, "params")["token"]).Should(Equal("[REDACTED]"))
+		Ω(entry(logger.InfoEntries, "params")["page"]).Should(Equal("2"))
+		payload := entry(logger.InfoEntries, "payload")
+		Ω(payload["user"]).Should(Equal("alice"))
+		Ω(payload["password"]).Should(Equal("[REDACTED]"))
+		Ω(payload["cards"]).Should(Equal([]interface{}{map[string]interface{}{"number": "[REDACTED]", "name": "visa"}}))
+		Ω(payload["note"]).Should(Equal("paid with [REDACTED]"))
+	})
+
+	It("redacts access log URIs", func() {
+		output := new(bytes.Buffer)
+		spec := &middleware.LogSpecification{Format: middleware.LogFormatCommon, Output: output, Redaction: redaction}
+		h := func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+			return goa.Response(ctx).Send(ctx, 200, "ok")
+		}
+		Ω(middleware.LogRequestMiddleware(spec)(h)(ctx, rw, req)).ShouldNot(HaveOccurred())
+		Ω(output.String()).ShouldNot(ContainSubstring("s3cr3t"))
+	})
+
+	It("redacts LogResponse bodies", func() {
+		redaction.Replacement = "***"
+		spec := &middleware.LogResponseSpecification{Redaction: redaction}
+		h := func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+			goa.Response(ctx).WriteHeader(200)
+			goa.Response(ctx).Write([]byte(`{"password":"pw","card":"1234-5678-9012-3456","id":12345678901234567890}`))
+			return nil
+		}
+		Ω(middleware.LogResponseMiddleware(spec)(h)(ctx, rw, req)).ShouldNot(HaveOccurred())
+		Ω(logger.InfoEntries).Should(HaveLen(1))
+		Ω(logger.InfoEntries[0].Data[5]).Should(MatchJSON(`{"password":"***","card":"***","id":12345678901234567890}`))
+		Ω(string(rw.Body)).Should(ContainSubstring(`"pw"`))
+	})
+
+	It("does not log truncated bodies that cannot be redacted", func() {
+		spec := &middleware.LogResponseSpecification{Redaction: redaction, MaxBodySize: 24}
+		h := func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+			goa.Response(ctx).Write([]byte(`{"password":"hunter2","name":"alice","role":"admin"}`))
+			return nil
+		}
+		Ω(middleware.LogResponseMiddleware(spec)(h)(ctx, rw, req)).ShouldNot(HaveOccurred())
+		body := fmt.Sprint(logger.InfoEntries[0].Data[5])
+		Ω(body).ShouldNot(ContainSubstring("hunter2"))
+		Ω(body).Should(HavePrefix("[redacted: unparseable]... [truncated"))
+	})
+
+	It("does not log bodies written in several chunks and truncated", func() {
+		spec := &middleware.LogResponseSpecification{Redaction: redaction, MaxBodySize: 32}
+		h := func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+			goa.Response(ctx).Write([]byte(`{"name":"alice",`))
+			goa.Response(ctx).Write([]byte(`"password":"hunter2",`))
+			goa.Response(ctx).Write([]byte(`"role":"admin"}`))
+			return nil
+		}
+		Ω(middleware.LogResponseMiddleware(spec)(h)(ctx, rw, req)).ShouldNot(HaveOccurred())
+		body := fmt.Sprint(logger.InfoEntries[0].Data[5])
+		Ω(body).ShouldNot(ContainSubstring("hunter2"))
+		Ω(body).Should(ContainSubstring("[redacted: unparseable]"))
+	})
+
+	It("redacts bodies written in several chunks", func() {
+		spec := &middleware.LogResponseSpecification{Redaction: redaction}
+		h := func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+			goa.Response(ctx).Write([]byte(`{"name":"alice",`))
+			goa.Response(ctx).Write([]byte(`"password":"hunter2"}`))
+			return nil
+		}
+		Ω(middleware.LogResponseMiddleware(spec)(h)(ctx, rw, req)).ShouldNot(HaveOccurred())
+		Ω(logger.InfoEntries[0].Data[5]).Should(MatchJSON(`{"name":"alice","password":"[REDACTED]"}`))
+	})
+})
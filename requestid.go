@@ -0,0 +1,159 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sync/atomic"
+	"time"
+
+	"github.com/goadesign/goa"
+
+	"golang.org/x/net/context"
+)
+
+// RequestIDGenerator generates request IDs.
+type RequestIDGenerator interface {
+	// NewRequestID returns a new request ID.
+	NewRequestID() string
+}
+
+// RequestIDGeneratorFunc is an adapter that makes it possible to use a function as a
+// RequestIDGenerator.
+type RequestIDGeneratorFunc func() string
+
+// NewRequestID calls f.
+func (f RequestIDGeneratorFunc) NewRequestID() string {
+	return f()
+}
+
+var (
+	// PrefixCounterGenerator generates request IDs made of a random prefix common to all the
+	// IDs generated by the process and a counter, e.g. "Jbdf3fHwYt-42".
+	PrefixCounterGenerator RequestIDGenerator = RequestIDGeneratorFunc(newPrefixCounterID)
+	// UUIDv4Generator generates random UUIDs as defined by RFC 4122.
+	UUIDv4Generator RequestIDGenerator = RequestIDGeneratorFunc(newUUIDv4)
+	// UUIDv7Generator generates time ordered UUIDs made of a millisecond timestamp and random
+	// bits.
+	UUIDv7Generator RequestIDGenerator = RequestIDGeneratorFunc(newUUIDv7)
+	// ULIDGenerator generates Universally Unique Lexicographically Sortable Identifiers, see
+	// https://github.com/ulid/spec.
+	ULIDGenerator RequestIDGenerator = RequestIDGeneratorFunc(newULID)
+)
+
+// RequestIDSpecification describes how the RequestID middleware reads and generates request
+// IDs.
+type RequestIDSpecification struct {
+	// Header is the name of the header that carries the request ID.
+	// Defaults to RequestIDHeader.
+	Header string
+	// Generator generates the IDs of the requests that do not carry a valid request ID.
+	// Defaults to PrefixCounterGenerator.
+	Generator RequestIDGenerator
+	// MaxLength is the maximum length of incoming request IDs, longer IDs are replaced with
+	// generated IDs.
+	// Optional, the length is not checked if 0.
+	MaxLength int
+	// ValidPattern is the pattern incoming request IDs must match, IDs that do not match are
+	// replaced with generated IDs, e.g. `^[A-Za-z0-9._-]+$`.
+	// Optional, any ID is accepted if nil.
+	ValidPattern *regexp.Regexp
+	// Echo is a flag that determines whether the request ID is set in the response header.
+	Echo bool
+}
+
+// RequestIDMiddleware is a middleware that injects a request ID into the context of each
+// request using the given specification. Retrieve it using ctx.Value(ReqIDKey). If the incoming
+// request carries a valid ID in the specification header then that value is used else a new ID
+// is generated.
+func RequestIDMiddleware(spec *RequestIDSpecification) goa.Middleware {
+	header := spec.Header
+	if header == "" {
+		header = RequestIDHeader
+	}
+	gen := spec.Generator
+	if gen == nil {
+		gen = PrefixCounterGenerator
+	}
+	return func(h goa.Handler) goa.Handler {
+		return func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+			id := req.Header.Get(header)
+			if id == "" || !spec.valid(id) {
+				id = gen.NewRequestID()
+			}
+			ctx = context.WithValue(ctx, ReqIDKey, id)
+			if spec.Echo {
+				goa.Response(ctx).Header().Set(header, id)
+			}
+
+			return h(ctx, rw, req)
+		}
+	}
+}
+
+// valid returns true if the given incoming request ID is accepted by the specification.
+func (spec *RequestIDSpecification) valid(id string) bool {
+	if spec.MaxLength > 0 && len(id) > spec.MaxLength {
+		return false
+	}
+	return spec.ValidPattern == nil || spec.ValidPattern.MatchString(id)
+}
+
+// newPrefixCounterID returns the process prefix followed by the next counter value.
+func newPrefixCounterID() string {
+	return fmt.Sprintf("%s-%d", reqPrefix, atomic.AddInt64(&reqID, 1))
+}
+
+// newUUIDv4 returns a random (version 4) UUID.
+func newUUIDv4() string {
+	var u [16]byte
+	io.ReadFull(rand.Reader, u[:])
+	u[6] = u[6]&0x0f | 0x40 // version 4
+	u[8] = u[8]&0x3f | 0x80 // RFC 4122 variant
+	return formatUUID(u)
+}
+
+// newUUIDv7 returns a time ordered (version 7) UUID.
+func newUUIDv7() string {
+	var u [16]byte
+	io.ReadFull(rand.Reader, u[6:])
+	ms := uint64(time.Now().UnixNano() / int64(time.Millisecond))
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], ms)
+	copy(u[:6], ts[2:])
+	u[6] = u[6]&0x0f | 0x70 // version 7
+	u[8] = u[8]&0x3f | 0x80 // RFC 4122 variant
+	return formatUUID(u)
+}
+
+// formatUUID returns the canonical string representation of a UUID.
+func formatUUID(u [16]byte) string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:])
+}
+
+// crockford is the Crockford base32 alphabet used by ULIDs.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// newULID returns a ULID made of the current time in milliseconds and 80 random bits.
+func newULID() string {
+	var u [16]byte
+	ms := uint64(time.Now().UnixNano() / int64(time.Millisecond))
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], ms)
+	copy(u[:6], ts[2:])
+	io.ReadFull(rand.Reader, u[6:])
+
+	// Encode the 128 bits as 26 base32 characters, the first character holds 3 bits.
+	var res [26]byte
+	hi := binary.BigEndian.Uint64(u[:8])
+	lo := binary.BigEndian.Uint64(u[8:])
+	for i := 25; i >= 0; i-- {
+		res[i] = crockford[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(res[:])
+}
@@ -0,0 +1,81 @@
+package middleware_test
+
+import (
+	"net/http"
+	"regexp"
+
+	"golang.org/x/net/context"
+
+	"github.com/goadesign/goa"
+	"github.com/goadesign/middleware"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RequestIDMiddleware", func() {
+	var spec *middleware.RequestIDSpecification
+	var req *http.Request
+	var rw *testResponseWriter
+	var newCtx context.Context
+
+	BeforeEach(func() {
+		spec = &middleware.RequestIDSpecification{}
+		var err error
+		req, err = http.NewRequest("GET", "/goo", nil)
+		Ω(err).ShouldNot(HaveOccurred())
+		rw = new(testResponseWriter)
+	})
+
+	serve := func() string {
+		ctx := newContext(newService(nil), rw, req, nil)
+		h := func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+			newCtx = ctx
+			return goa.Response(ctx).Send(ctx, 200, "ok")
+		}
+		Ω(middleware.RequestIDMiddleware(spec)(h)(ctx, rw, req)).ShouldNot(HaveOccurred())
+		return newCtx.Value(middleware.ReqIDKey).(string)
+	}
+
+	It("reads the ID from the configured header and echoes it", func() {
+		spec.Header = "X-Correlation-Id"
+		spec.Echo = true
+		req.Header.Set("X-Correlation-Id", "abc")
+		Ω(serve()).Should(Equal("abc"))
+		Ω(rw.Header().Get("X-Correlation-Id")).Should(Equal("abc"))
+	})
+
+	It("replaces invalid incoming IDs", func() {
+		spec.MaxLength = 8
+		spec.ValidPattern = regexp.MustCompile(`^[a-z0-9-]+$`)
+		spec.Generator = middleware.RequestIDGeneratorFunc(func() string { return "generated" })
+		req.Header.Set(middleware.RequestIDHeader, "much-too-long")
+		Ω(serve()).Should(Equal("generated"))
+		req.Header.Set(middleware.RequestIDHeader, "in valid")
+		Ω(serve()).Should(Equal("generated"))
+		req.Header.Set(middleware.RequestIDHeader, "valid")
+		Ω(serve()).Should(Equal("valid"))
+	})
+
+	It("generates UUIDv4 IDs", func() {
+		spec.Generator = middleware.UUIDv4Generator
+		Ω(serve()).Should(MatchRegexp(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`))
+	})
+
+	It("generates UUIDv7 IDs", func() {
+		spec.Generator = middleware.UUIDv7Generator
+		first := serve()
+		Ω(first).Should(MatchRegexp(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`))
+		Ω(serve()[:8] >= first[:8]).Should(BeTrue())
+	})
+
+	It("generates ULIDs", func() {
+		spec.Generator = middleware.ULIDGenerator
+		Ω(serve()).Should(MatchRegexp(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`))
+	})
+
+	It("generates prefix counter IDs by default", func() {
+		first := serve()
+		Ω(first).Should(MatchRegexp(`^.{10}-\d+$`))
+		Ω(serve()).ShouldNot(Equal(first))
+	})
+})
@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"math/rand"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+// LogSampling describes which requests the LogRequest middleware logs. Requests carrying the
+// ForceHeader header are always logged, requests whose path is excluded are never logged
+// otherwise. The remaining requests are logged if they failed, if they were slow or if they are
+// part of the sampled ratio.
+type LogSampling struct {
+	// Ratio is the fraction of requests that are logged, between 0 and 1.
+	// Defaults to 0, only the requests selected by the other rules are logged.
+	Ratio float64
+	// AlwaysLogErrors is a flag that determines whether requests whose response status is
+	// 400 or more are always logged.
+	AlwaysLogErrors bool
+	// SlowThreshold is the latency above which requests are always logged.
+	// Optional, latency is not taken into account if 0.
+	SlowThreshold time.Duration
+	// IncludePaths lists the patterns matching the paths of the requests that may be logged.
+	// Optional, all paths may be logged if empty.
+	IncludePaths []*regexp.Regexp
+	// ExcludePaths lists the patterns matching the paths of the requests that are not logged,
+	// e.g. "^/healthz$".
+	ExcludePaths []*regexp.Regexp
+	// ForceHeader is the name of a request header that forces logging when present.
+	// Optional, logging cannot be forced if empty.
+	ForceHeader string
+}
+
+// forced returns true if the request must be logged regardless of the other rules.
+func (s *LogSampling) forced(req *http.Request) bool {
+	return s.ForceHeader != "" && req.Header.Get(s.ForceHeader) != ""
+}
+
+// excluded returns true if the request path is excluded from logging.
+func (s *LogSampling) excluded(req *http.Request) bool {
+	path := req.URL.Path
+	for _, p := range s.ExcludePaths {
+		if p.MatchString(path) {
+			return true
+		}
+	}
+	if len(s.IncludePaths) == 0 {
+		return false
+	}
+	for _, p := range s.IncludePaths {
+		if p.MatchString(path) {
+			return false
+		}
+	}
+	return true
+}
+
+// sampled returns true if the completed request must be logged.
+func (s *LogSampling) sampled(req *http.Request, status int, latency time.Duration) bool {
+	if s.forced(req) {
+		return true
+	}
+	if s.excluded(req) {
+		return false
+	}
+	if s.AlwaysLogErrors && status >= 400 {
+		return true
+	}
+	if s.SlowThreshold > 0 && latency >= s.SlowThreshold {
+		return true
+	}
+	return s.Ratio > 0 && rand.Float64() < s.Ratio
+}
@@ -0,0 +1,104 @@
+package middleware_test
+
+import (
+	"net/http"
+	"regexp"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/goadesign/goa"
+	"github.com/goadesign/middleware"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("LogSampling", func() {
+	var sampling *middleware.LogSampling
+	var spec *middleware.LogSpecification
+	var logger *testLogger
+	var status int
+	var delay time.Duration
+
+	BeforeEach(func() {
+		sampling = &middleware.LogSampling{}
+		spec = &middleware.LogSpecification{Sampling: sampling}
+		logger = new(testLogger)
+		status = 200
+		delay = 0
+	})
+
+	serve := func(path string, header http.Header) {
+		req, err := http.NewRequest("GET", path, nil)
+		Ω(err).ShouldNot(HaveOccurred())
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		rw := new(testResponseWriter)
+		ctx := newContext(newService(logger), rw, req, nil)
+		h := func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+			time.Sleep(delay)
+			return goa.Response(ctx).Send(ctx, status, "ok")
+		}
+		Ω(middleware.LogRequestMiddleware(spec)(h)(ctx, rw, req)).ShouldNot(HaveOccurred())
+	}
+
+	It("logs the configured ratio of requests", func() {
+		serve("/goo", nil)
+		Ω(logger.InfoEntries).Should(BeEmpty())
+		sampling.Ratio = 1
+		serve("/goo", nil)
+		Ω(logger.InfoEntries).Should(HaveLen(2))
+		Ω(logger.InfoEntries[0].Msg).Should(Equal("started"))
+		Ω(logger.InfoEntries[1].Msg).Should(Equal("completed"))
+	})
+
+	It("always logs errors", func() {
+		sampling.AlwaysLogErrors = true
+		status = 500
+		serve("/goo", nil)
+		Ω(logger.InfoEntries).Should(HaveLen(2))
+	})
+
+	It("always logs slow requests", func() {
+		sampling.SlowThreshold = time.Millisecond
+		delay = 2 * time.Millisecond
+		serve("/goo", nil)
+		Ω(logger.InfoEntries).Should(HaveLen(2))
+	})
+
+	It("skips excluded paths", func() {
+		sampling.Ratio = 1
+		sampling.AlwaysLogErrors = true
+		sampling.ExcludePaths = []*regexp.Regexp{regexp.MustCompile("^/healthz$")}
+		status = 500
+		serve("/healthz", nil)
+		Ω(logger.InfoEntries).Should(BeEmpty())
+	})
+
+	It("only logs included paths", func() {
+		sampling.Ratio = 1
+		sampling.IncludePaths = []*regexp.Regexp{regexp.MustCompile("^/api/")}
+		serve("/goo", nil)
+		Ω(logger.InfoEntries).Should(BeEmpty())
+		serve("/api/goo", nil)
+		Ω(logger.InfoEntries).Should(HaveLen(2))
+	})
+
+	It("logs requests with the force header", func() {
+		sampling.ForceHeader = "X-Log"
+		sampling.ExcludePaths = []*regexp.Regexp{regexp.MustCompile("^/healthz$")}
+		serve("/healthz", http.Header{"X-Log": {"1"}})
+		Ω(logger.InfoEntries).Should(HaveLen(2))
+	})
+
+	It("logs errors at error level", func() {
+		spec.Sampling = nil
+		spec.ErrorStatus = 500
+		status = 503
+		serve("/goo", nil)
+		Ω(logger.InfoEntries).Should(HaveLen(1))
+		Ω(logger.ErrorEntries).Should(HaveLen(1))
+		Ω(logger.ErrorEntries[0].Msg).Should(Equal("completed"))
+	})
+})
@@ -0,0 +1,241 @@
+package middleware
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goadesign/goa"
+
+	"golang.org/x/net/context"
+)
+
+// slowStackSizeDefault is the default maximum size of the stack snapshots taken by
+// SlowRequestMiddleware.
+const slowStackSizeDefault = 64 << 10 // 64KB
+
+// slowStackIntervalDefault is the default minimum interval between two stack snapshots taken by
+// SlowRequestMiddleware.
+const slowStackIntervalDefault = time.Second
+
+// maxStackDumpSize is the maximum size of the goroutine dump that stack snapshots are extracted
+// from. It bounds the memory used by the snapshots of processes running many goroutines.
+const maxStackDumpSize = 16 << 20 // 16MB
+
+// SlowRequestSpecification describes the requests that the SlowRequestMiddleware middleware
+// reports as slow.
+type SlowRequestSpecification struct {
+	// Threshold is the latency above which requests are reported as slow.
+	// Required unless all the requests are matched by Routes.
+	Threshold time.Duration
+	// Routes lists thresholds that override Threshold for specific routes, the first route
+	// matching the request is used.
+	Routes []*SlowRoute
+	// StackSize is the maximum size in bytes of the goroutine stack snapshots, longer snapshots
+	// are truncated.
+	// Defaults to 64KB.
+	StackSize int
+	// StackInterval is the minimum interval between two stack snapshots. Taking a snapshot
+	// stops the world, the stack of the requests that cross their threshold less than
+	// StackInterval after the last snapshot is reported as "[stack omitted]" so that the
+	// snapshots do not add to the load when many requests are slow.
+	// Defaults to 1s.
+	StackInterval time.Duration
+	// AllGoroutines is a flag that determines whether the snapshot includes the stacks of all
+	// the goroutines rather than only the stack of the goroutine running the request.
+	AllGoroutines bool
+	// OnSlow is called when a request crosses its threshold, while the request is still
+	// running. It may be used to raise alerts. OnSlow runs in a separate goroutine but the
+	// request does not complete before it returns so it must not block.
+	// Optional.
+	OnSlow func(ctx context.Context, e *SlowRequestEvent)
+}
+
+// SlowRoute defines the threshold of the requests matching a method and path pattern.
+type SlowRoute struct {
+	// Method is the HTTP method of the route.
+	// Optional, all methods match if empty.
+	Method string
+	// Path is the pattern matching the request paths of the route.
+	// Optional, all paths match if nil.
+	Path *regexp.Regexp
+	// Threshold is the latency above which requests are reported as slow, 0 disables slow
+	// request detection for the route.
+	Threshold time.Duration
+}
+
+// SlowRequestEvent describes a request that crossed its slow request threshold.
+type SlowRequestEvent struct {
+	// RequestID is the request ID set by the RequestID middleware if any.
+	RequestID string
+	// Method is the request HTTP method.
+	Method string
+	// Path is the request path.
+	Path string
+	// Threshold is the threshold that the request crossed.
+	Threshold time.Duration
+	// StartedAt is the time the request was received.
+	StartedAt time.Time
+	// Stack is the goroutine stack snapshot taken when the request crossed the threshold.
+	Stack string
+}
+
+// SlowRequest creates a middleware that reports the requests taking longer than threshold.
+// See SlowRequestMiddleware.
+func SlowRequest(threshold time.Duration) goa.Middleware {
+	return SlowRequestMiddleware(&SlowRequestSpecification{Threshold: threshold})
+}
+
+// SlowRequestMiddleware creates a middleware that measures the request latency and reports the
+// requests that cross the threshold defined by the specification. Slow requests are reported as
+// soon as they cross the threshold, while they are still running: the middleware logs an entry
+// with a "warn" level key that includes the request ID and a snapshot of the stack of the
+// goroutine running the request, then calls the specification OnSlow hook. A second entry with
+// the total duration is logged once the request completes. The stack snapshots are rate limited,
+// see StackInterval.
+func SlowRequestMiddleware(spec *SlowRequestSpecification) goa.Middleware {
+	stackSize := spec.StackSize
+	if stackSize == 0 {
+		stackSize = slowStackSizeDefault
+	}
+	interval := spec.StackInterval
+	if interval == 0 {
+		interval = slowStackIntervalDefault
+	}
+	var (
+		mu           sync.Mutex
+		lastSnapshot time.Time
+	)
+	snapshot := func(gid string) string {
+		mu.Lock()
+		now := time.Now()
+		if !lastSnapshot.IsZero() && now.Sub(lastSnapshot) < interval {
+			mu.Unlock()
+			return omittedStack
+		}
+		lastSnapshot = now
+		mu.Unlock()
+		return stackSnapshot(gid, stackSize, spec.AllGoroutines)
+	}
+	return func(h goa.Handler) goa.Handler {
+		return func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+			threshold := spec.threshold(req)
+			if threshold <= 0 {
+				return h(ctx, rw, req)
+			}
+			startedAt := time.Now()
+			gid := goroutineID()
+			var reqID string
+			if id := ctx.Value(ReqIDKey); id != nil {
+				reqID = fmt.Sprintf("%v", id)
+			}
+			fired := make(chan struct{})
+			timer := time.AfterFunc(threshold, func() {
+				defer close(fired)
+				e := &SlowRequestEvent{
+					RequestID: reqID,
+					Method:    req.Method,
+					Path:      req.URL.Path,
+					Threshold: threshold,
+					StartedAt: startedAt,
+					Stack:     snapshot(gid),
+				}
+				goa.Info(ctx, "slow request", "level", "warn", "req_id", e.RequestID, "method", e.Method,
+					"path", e.Path, "threshold", threshold.String(), "stack", e.Stack)
+				if spec.OnSlow != nil {
+					spec.OnSlow(ctx, e)
+				}
+			})
+			err := h(ctx, rw, req)
+			if !timer.Stop() {
+				<-fired
+				goa.Info(ctx, "slow request completed", "level", "warn", "req_id", reqID, "method", req.Method,
+					"path", req.URL.Path, "time", time.Since(startedAt).String())
+			}
+			return err
+		}
+	}
+}
+
+// threshold returns the slow request threshold that applies to the given request.
+func (spec *SlowRequestSpecification) threshold(req *http.Request) time.Duration {
+	for _, r := range spec.Routes {
+		if r.Method != "" && r.Method != req.Method {
+			continue
+		}
+		if r.Path != nil && !r.Path.MatchString(req.URL.Path) {
+			continue
+		}
+		return r.Threshold
+	}
+	return spec.Threshold
+}
+
+// goroutineID returns the ID of the calling goroutine as reported in stack traces.
+func goroutineID() string {
+	buf := make([]byte, 64)
+	buf = buf[:runtime.Stack(buf, false)]
+	// The first line is "goroutine 42 [running]:"
+	fields := bytes.Fields(buf)
+	if len(fields) < 2 {
+		return ""
+	}
+	return string(fields[1])
+}
+
+// stackSnapshot returns the stack of the goroutine with the given ID or the stacks of all the
+// goroutines if all is true, truncated to size bytes. It returns missingStack if the goroutine
+// cannot be found.
+func stackSnapshot(gid string, size int, all bool) string {
+	// Grow the buffer until it holds the stacks of all the goroutines, as done by runtime/pprof,
+	// so that the goroutine is found even when many goroutines are running.
+	buf := make([]byte, 64<<10)
+	complete := false
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			complete = true
+			break
+		}
+		if len(buf) >= maxStackDumpSize {
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	if all {
+		return truncateStack(string(buf), size)
+	}
+	if gid == "" || !complete {
+		// the stack of the goroutine may be cut or missing from an incomplete dump.
+		return missingStack
+	}
+	header := "goroutine " + gid + " ["
+	for _, s := range strings.Split(string(buf), "\n\n") {
+		if strings.HasPrefix(s, header) {
+			return truncateStack(s, size)
+		}
+	}
+	return missingStack
+}
+
+// missingStack is the stack snapshot reported when the goroutine running the request cannot be
+// found.
+const missingStack = "[stack unavailable]"
+
+// omittedStack is the stack snapshot reported when the snapshot is skipped because the previous
+// one was taken less than StackInterval ago.
+const omittedStack = "[stack omitted]"
+
+// truncateStack returns the first size bytes of stack.
+func truncateStack(stack string, size int) string {
+	if len(stack) > size {
+		return stack[:size]
+	}
+	return stack
+}
@@ -0,0 +1,119 @@
+package middleware_test
+
+import (
+	"net/http"
+	"regexp"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/goadesign/goa"
+	"github.com/goadesign/middleware"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SlowRequestMiddleware", func() {
+	var spec *middleware.SlowRequestSpecification
+	var logger *testLogger
+	var events []*middleware.SlowRequestEvent
+	var delay time.Duration
+	var mw goa.Middleware
+
+	BeforeEach(func() {
+		mw = nil
+		events = nil
+		delay = 20 * time.Millisecond
+		spec = &middleware.SlowRequestSpecification{
+			Threshold: 5 * time.Millisecond,
+			OnSlow: func(ctx context.Context, e *middleware.SlowRequestEvent) {
+				events = append(events, e)
+			},
+		}
+		logger = new(testLogger)
+	})
+
+	serve := func(path string) {
+		req, err := http.NewRequest("GET", path, nil)
+		Ω(err).ShouldNot(HaveOccurred())
+		rw := new(testResponseWriter)
+		ctx := newContext(newService(logger), rw, req, nil)
+		ctx = context.WithValue(ctx, middleware.ReqIDKey, "req-1")
+		h := func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+			time.Sleep(delay)
+			return goa.Response(ctx).Send(ctx, 200, "ok")
+		}
+		if mw == nil {
+			mw = middleware.SlowRequestMiddleware(spec)
+		}
+		Ω(mw(h)(ctx, rw, req)).ShouldNot(HaveOccurred())
+	}
+
+	It("reports slow requests while they run", func() {
+		serve("/goo")
+		Ω(events).Should(HaveLen(1))
+		Ω(events[0].RequestID).Should(Equal("req-1"))
+		Ω(events[0].Path).Should(Equal("/goo"))
+		Ω(events[0].Stack).Should(ContainSubstring("time.Sleep"))
+		Ω(logger.InfoEntries).Should(HaveLen(2))
+		Ω(logger.InfoEntries[0].Msg).Should(Equal("slow request"))
+		Ω(logger.InfoEntries[0].Data[:4]).Should(Equal([]interface{}{"level", "warn", "req_id", "req-1"}))
+		Ω(logger.InfoEntries[1].Msg).Should(Equal("slow request completed"))
+		Ω(logger.InfoEntries[1].Data[:4]).Should(Equal([]interface{}{"level", "warn", "req_id", "req-1"}))
+	})
+
+	It("limits the rate of the stack snapshots", func() {
+		serve("/goo")
+		serve("/goo")
+		Ω(events).Should(HaveLen(2))
+		Ω(events[0].Stack).Should(ContainSubstring("time.Sleep"))
+		Ω(events[1].Stack).Should(Equal("[stack omitted]"))
+
+		spec.StackInterval = time.Nanosecond
+		mw = nil
+		serve("/goo")
+		serve("/goo")
+		Ω(events[3].Stack).Should(ContainSubstring("time.Sleep"))
+	})
+
+	It("finds the request stack among many goroutines", func() {
+		spec.StackSize = 4 << 10
+		release := make(chan struct{})
+		defer close(release)
+		for i := 0; i < 2000; i++ {
+			go func() { <-release }()
+		}
+		// Serve the request from a goroutine created after the others so that its stack comes
+		// last in the goroutine dump.
+		done := make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			defer close(done)
+			serve("/goo")
+		}()
+		<-done
+		Ω(events).Should(HaveLen(1))
+		Ω(events[0].Stack).Should(ContainSubstring("time.Sleep"))
+		Ω(regexp.MustCompile(`(?m)^goroutine `).FindAllString(events[0].Stack, -1)).Should(HaveLen(1))
+		Ω(len(events[0].Stack)).Should(BeNumerically("<=", 4<<10))
+	})
+
+	It("ignores fast requests", func() {
+		delay = 0
+		serve("/goo")
+		Ω(events).Should(BeEmpty())
+		Ω(logger.InfoEntries).Should(BeEmpty())
+	})
+
+	It("uses per route thresholds", func() {
+		spec.Routes = []*middleware.SlowRoute{
+			{Path: regexp.MustCompile("^/stream"), Threshold: 0},
+			{Method: "GET", Path: regexp.MustCompile("^/reports"), Threshold: time.Second},
+		}
+		serve("/stream")
+		serve("/reports")
+		Ω(events).Should(BeEmpty())
+		serve("/goo")
+		Ω(events).Should(HaveLen(1))
+	})
+})
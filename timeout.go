@@ -0,0 +1,339 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goadesign/goa"
+
+	"golang.org/x/net/context"
+)
+
+const (
+	// RequestTimeoutHeader is the name of the header commonly used by clients and gateways to
+	// set the remaining time budget of a request.
+	RequestTimeoutHeader = "X-Request-Timeout"
+	// GRPCTimeoutHeader is the name of the header used by gRPC clients to set the remaining time
+	// budget of a request, e.g. "100m" for 100 milliseconds.
+	GRPCTimeoutHeader = "grpc-timeout"
+)
+
+// TimeoutSpecification describes how the Timeout middleware sets and enforces the request
+// deadlines.
+type TimeoutSpecification struct {
+	// Timeout is the maximum duration of the requests, 0 disables the timeout.
+	Timeout time.Duration
+	// Routes override the timeout of the requests matching a method and path pattern, the
+	// first matching route is used.
+	// Optional, Timeout applies to all requests if empty.
+	Routes []*TimeoutRoute
+	// TimeoutHeaders lists the request headers read to honor the timeout supplied by the client,
+	// e.g. RequestTimeoutHeader or GRPCTimeoutHeader. The first header present sets the request
+	// timeout, capped by the configured timeout. Client supplied timeouts are ignored for the
+	// requests that have no timeout. GRPCTimeoutHeader values use the gRPC format, other headers
+	// use Go duration strings ("1.5s") or integer milliseconds. Invalid values are ignored.
+	// Optional, client supplied timeouts are ignored if empty.
+	TimeoutHeaders []string
+	// Enforce is a flag that determines whether the middleware runs the handler in its own
+	// goroutine against a buffered response writer and responds as soon as the deadline
+	// expires, even if the handler does not check the context. The handler response is written
+	// only if it completes before the deadline, later writes are discarded and fail with
+	// http.ErrHandlerTimeout.
+	Enforce bool
+	// Status is the status of the responses written when the deadline expires in Enforce mode,
+	// typically http.StatusServiceUnavailable or http.StatusGatewayTimeout.
+	// Defaults to http.StatusServiceUnavailable.
+	Status int
+	// ErrorResponder writes the response when the deadline expires in Enforce mode.
+	// Defaults to WriteError with Status.
+	ErrorResponder func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error
+}
+
+// TimeoutRoute defines the timeout of the requests matching a method and path pattern.
+type TimeoutRoute struct {
+	// Method is the HTTP method of the route.
+	// Optional, all methods match if empty.
+	Method string
+	// Path is the pattern matching the request paths of the route.
+	// Optional, all paths match if nil.
+	Path *regexp.Regexp
+	// Timeout is the maximum duration of the requests, 0 disables the timeout for the route.
+	Timeout time.Duration
+}
+
+// TimeoutMiddleware is a middleware that sets a deadline in the request context using the given
+// specification. In Enforce mode it also writes an error response when the deadline expires
+// before the handler responds. See Timeout.
+func TimeoutMiddleware(spec *TimeoutSpecification) goa.Middleware {
+	return func(h goa.Handler) goa.Handler {
+		return func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+			timeout := spec.timeout(req)
+			if timeout <= 0 {
+				return h(ctx, rw, req)
+			}
+			if ct, ok := spec.clientTimeout(req, timeout); ok && ct < timeout {
+				timeout = ct
+			}
+			// We discard the cancel function because the goa handler already takes
+			// care of canceling on completion.
+			nctx, _ := context.WithTimeout(ctx, timeout)
+			if spec.Enforce {
+				return spec.enforce(ctx, nctx, rw, req, h, timeout)
+			}
+			return h(nctx, rw, req)
+		}
+	}
+}
+
+// enforce runs the handler against a buffered writer and writes the timeout response if the
+// deadline of nctx expires first.
+func (spec *TimeoutSpecification) enforce(ctx, nctx context.Context, rw http.ResponseWriter, req *http.Request, h goa.Handler, timeout time.Duration) error {
+	resp := goa.Response(ctx)
+	tw := &timeoutWriter{header: make(http.Header)}
+	for k, v := range resp.Header() {
+		// Copy the values so that the handler never writes to the upstream header.
+		tw.header[k] = append([]string(nil), v...)
+	}
+	// The handler gets its own response data so that it never modifies the response state read
+	// by the upstream middlewares once the deadline expired.
+	hctx := goa.NewContext(nctx, tw, req, nil)
+	if r := goa.Request(ctx); r != nil {
+		hr := goa.Request(hctx)
+		hr.Params = r.Params
+		hr.Payload = r.Payload
+	}
+
+	done := make(chan error, 1)
+	panicked := make(chan interface{}, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				hp := newHandlerPanic(p)
+				var v interface{} = hp
+				if p == http.ErrAbortHandler {
+					// The HTTP server compares the value to abort the connection silently.
+					v = p
+				}
+				if !tw.deliver(panicked, v) {
+					goa.Error(ctx, "PANIC after timeout", "error", p, "stack", hp.stack)
+				}
+			}
+		}()
+		done <- h(hctx, tw, req)
+	}()
+
+	select {
+	case p := <-panicked:
+		// Panic in the request goroutine so that the Recover middleware handles it, the value
+		// carries the stack of the handler goroutine.
+		panic(p)
+	case err := <-done:
+		tw.flush(resp)
+		return err
+	case <-nctx.Done():
+		tw.expire()
+		// The handler may have panicked right when the deadline expired, the select above picks
+		// one of the ready cases at random. Later panics are logged by the handler goroutine.
+		select {
+		case p := <-panicked:
+			panic(p)
+		default:
+		}
+		if nctx.Err() != context.DeadlineExceeded {
+			// The request was canceled, e.g. the client went away.
+			return nctx.Err()
+		}
+		goa.Error(ctx, "request timed out", "path", req.URL.Path, "timeout", timeout.String())
+		return spec.respond(ctx, rw, req)
+	}
+}
+
+// respond writes the timeout response.
+func (spec *TimeoutSpecification) respond(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+	if spec.ErrorResponder != nil {
+		return spec.ErrorResponder(ctx, rw, req)
+	}
+	status := spec.Status
+	if status == 0 {
+		status = http.StatusServiceUnavailable
+	}
+	return WriteError(ctx, responseWriter(ctx, rw), req, status, "request timed out")
+}
+
+// timeout returns the timeout of the given request.
+func (spec *TimeoutSpecification) timeout(req *http.Request) time.Duration {
+	for _, r := range spec.Routes {
+		if r.Method != "" && r.Method != req.Method {
+			continue
+		}
+		if r.Path != nil && !r.Path.MatchString(req.URL.Path) {
+			continue
+		}
+		return r.Timeout
+	}
+	return spec.Timeout
+}
+
+// clientTimeout returns the timeout set by the first timeout header present in the request
+// capped by max.
+func (spec *TimeoutSpecification) clientTimeout(req *http.Request, max time.Duration) (time.Duration, bool) {
+	for _, name := range spec.TimeoutHeaders {
+		if v := req.Header.Get(name); v != "" {
+			d, ok := parseTimeout(name, v, max)
+			return d, ok && d > 0
+		}
+	}
+	return 0, false
+}
+
+// grpcTimeoutUnits lists the units of gRPC timeouts from the most to the least precise.
+var grpcTimeoutUnits = []struct {
+	unit byte
+	d    time.Duration
+}{
+	{'n', time.Nanosecond},
+	{'u', time.Microsecond},
+	{'m', time.Millisecond},
+	{'S', time.Second},
+	{'M', time.Minute},
+	{'H', time.Hour},
+}
+
+// parseTimeout parses the value of the given timeout header. The result is capped by max.
+func parseTimeout(header, v string, max time.Duration) (time.Duration, bool) {
+	if strings.EqualFold(header, GRPCTimeoutHeader) {
+		// gRPC timeouts are made of at most 8 digits followed by a unit.
+		if len(v) < 2 || len(v) > 9 {
+			return 0, false
+		}
+		n, err := strconv.ParseInt(v[:len(v)-1], 10, 64)
+		if err != nil || n < 0 {
+			return 0, false
+		}
+		for _, u := range grpcTimeoutUnits {
+			if u.unit == v[len(v)-1] {
+				return scaleTimeout(n, u.d, max), true
+			}
+		}
+		return 0, false
+	}
+	if ms, err := strconv.ParseInt(v, 10, 64); err == nil {
+		if ms < 0 {
+			return 0, false
+		}
+		return scaleTimeout(ms, time.Millisecond, max), true
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, false
+	}
+	if d > max {
+		d = max
+	}
+	return d, true
+}
+
+// scaleTimeout returns n times unit capped by max. The value is capped before the
+// multiplication so that large client supplied values do not overflow.
+func scaleTimeout(n int64, unit, max time.Duration) time.Duration {
+	if n > int64(max/unit) {
+		return max
+	}
+	return time.Duration(n) * unit
+}
+
+// formatTimeout formats the given positive duration as a value of the given timeout header.
+// Durations are rounded up so that a remaining budget is never sent as 0, which denotes no
+// timeout.
+func formatTimeout(header string, d time.Duration) string {
+	if strings.EqualFold(header, GRPCTimeoutHeader) {
+		// Use the most precise unit that fits in 8 digits.
+		for _, u := range grpcTimeoutUnits {
+			if n := (d + u.d - 1) / u.d; n < 1e8 {
+				return fmt.Sprintf("%d%c", n, u.unit)
+			}
+		}
+	}
+	return strconv.FormatInt(int64((d+time.Millisecond-1)/time.Millisecond), 10)
+}
+
+// timeoutWriter is a response writer that buffers the response until the handler completes.
+// Writes made after the deadline expired are discarded.
+type timeoutWriter struct {
+	mu      sync.Mutex
+	header  http.Header
+	status  int
+	buf     []byte
+	timeout bool
+}
+
+// Header returns the buffered response header.
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+// WriteHeader records the response status.
+func (tw *timeoutWriter) WriteHeader(status int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timeout || tw.status != 0 {
+		return
+	}
+	tw.status = status
+}
+
+// Write buffers the data, it fails with http.ErrHandlerTimeout once the deadline expired.
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timeout {
+		return 0, http.ErrHandlerTimeout
+	}
+	if tw.status == 0 {
+		tw.status = http.StatusOK
+	}
+	tw.buf = append(tw.buf, b...)
+	return len(b), nil
+}
+
+// expire records that the deadline expired.
+func (tw *timeoutWriter) expire() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	tw.timeout = true
+}
+
+// deliver sends the handler panic value p to panicked unless the deadline expired, it returns
+// false if the deadline expired. The check and the send happen atomically with respect to expire
+// so that a panic is either received by enforce or logged by the handler goroutine.
+func (tw *timeoutWriter) deliver(panicked chan<- interface{}, p interface{}) bool {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timeout {
+		return false
+	}
+	panicked <- p
+	return true
+}
+
+// flush writes the buffered response to rw.
+func (tw *timeoutWriter) flush(rw http.ResponseWriter) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	dst := rw.Header()
+	for k, v := range tw.header {
+		dst[k] = v
+	}
+	if tw.status == 0 {
+		return
+	}
+	rw.WriteHeader(tw.status)
+	if len(tw.buf) > 0 {
+		rw.Write(tw.buf)
+	}
+}
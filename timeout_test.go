@@ -0,0 +1,199 @@
+package middleware_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/goadesign/goa"
+	"github.com/goadesign/middleware"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("TimeoutMiddleware", func() {
+	var spec *middleware.TimeoutSpecification
+	var req *http.Request
+	var rw *testResponseWriter
+	var ctx context.Context
+
+	BeforeEach(func() {
+		spec = &middleware.TimeoutSpecification{Timeout: 10 * time.Millisecond, Enforce: true}
+		var err error
+		req, err = http.NewRequest("GET", "/goo", nil)
+		Ω(err).ShouldNot(HaveOccurred())
+		rw = new(testResponseWriter)
+		ctx = newContext(newService(nil), rw, req, nil)
+	})
+
+	It("writes the handler response when it completes in time", func() {
+		h := func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+			rw.Header().Set("X-Foo", "bar")
+			return goa.Response(ctx).Send(ctx, 201, "ok")
+		}
+		Ω(middleware.TimeoutMiddleware(spec)(h)(ctx, rw, req)).ShouldNot(HaveOccurred())
+		Ω(goa.Response(ctx).Status).Should(Equal(201))
+		Ω(rw.Header().Get("X-Foo")).Should(Equal("bar"))
+		Ω(string(rw.Body)).Should(ContainSubstring("ok"))
+	})
+
+	It("responds when the deadline expires and discards late writes", func() {
+		spec.Status = http.StatusGatewayTimeout
+		late := make(chan error, 1)
+		release := make(chan struct{})
+		h := func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+			<-release
+			_, err := rw.Write([]byte("late"))
+			late <- err
+			return nil
+		}
+		started := time.Now()
+		Ω(middleware.TimeoutMiddleware(spec)(h)(ctx, rw, req)).ShouldNot(HaveOccurred())
+		Ω(time.Since(started)).Should(BeNumerically("<", time.Second))
+		Ω(rw.Status).Should(Equal(http.StatusGatewayTimeout))
+		var p middleware.Problem
+		Ω(json.Unmarshal(rw.Body, &p)).ShouldNot(HaveOccurred())
+		Ω(p.Status).Should(Equal(http.StatusGatewayTimeout))
+		close(release)
+		Ω(<-late).Should(Equal(http.ErrHandlerTimeout))
+		Ω(string(rw.Body)).ShouldNot(ContainSubstring("late"))
+	})
+
+	It("writes a single response when the handler fails after the deadline", func() {
+		h := func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		Ω(middleware.TimeoutMiddleware(spec)(h)(ctx, rw, req)).ShouldNot(HaveOccurred())
+		Ω(rw.Status).Should(Equal(http.StatusServiceUnavailable))
+		var p middleware.Problem
+		Ω(json.Unmarshal(rw.Body, &p)).ShouldNot(HaveOccurred())
+	})
+
+	It("does not share the header values with the handler", func() {
+		goa.Response(ctx).Header().Set("X-Foo", "bar")
+		release := make(chan struct{})
+		h := func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+			<-ctx.Done()
+			rw.Header()["X-Foo"][0] = "baz"
+			close(release)
+			return nil
+		}
+		Ω(middleware.TimeoutMiddleware(spec)(h)(ctx, rw, req)).ShouldNot(HaveOccurred())
+		<-release
+		Ω(rw.Header().Get("X-Foo")).Should(Equal("bar"))
+	})
+
+	It("uses the error responder", func() {
+		spec.ErrorResponder = func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+			return goa.Response(ctx).Send(ctx, 503, "busy")
+		}
+		h := func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+			<-ctx.Done()
+			return nil
+		}
+		middleware.TimeoutMiddleware(spec)(h)(ctx, rw, req)
+		Ω(rw.Status).Should(Equal(503))
+		Ω(string(rw.Body)).Should(ContainSubstring("busy"))
+	})
+
+	It("applies the route timeouts", func() {
+		spec.Routes = []*middleware.TimeoutRoute{{Path: regexp.MustCompile(`^/goo$`), Timeout: time.Hour}}
+		var deadline time.Time
+		h := func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+			deadline, _ = ctx.Deadline()
+			return goa.Response(ctx).Send(ctx, 200, "ok")
+		}
+		Ω(middleware.TimeoutMiddleware(spec)(h)(ctx, rw, req)).ShouldNot(HaveOccurred())
+		Ω(deadline).Should(BeTemporally(">", time.Now().Add(time.Minute)))
+	})
+
+	It("propagates handler panics with their stack", func() {
+		h := func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+			panicInHandler()
+			return nil
+		}
+		Ω(func() { middleware.TimeoutMiddleware(spec)(h)(ctx, rw, req) }).Should(Panic())
+
+		reporter := new(middleware.MemoryReporter)
+		recoverSpec := &middleware.RecoverSpecification{Reporters: []middleware.PanicReporter{reporter.Report}}
+		rw = new(testResponseWriter)
+		ctx = newContext(newService(nil), rw, req, nil)
+		mw := middleware.RecoverMiddleware(recoverSpec)(middleware.TimeoutMiddleware(spec)(h))
+		Ω(mw(ctx, rw, req)).Should(MatchError("panic: boom"))
+		Ω(rw.Status).Should(Equal(500))
+		events := reporter.Events()
+		Ω(events).Should(HaveLen(1))
+		Ω(events[0].Value).Should(Equal("boom"))
+		Ω(events[0].Frames[0].Function).Should(HaveSuffix("panicInHandler"))
+	})
+
+	Context("with client supplied timeouts", func() {
+		var deadline time.Time
+
+		BeforeEach(func() {
+			spec.Timeout = time.Minute
+			spec.TimeoutHeaders = []string{middleware.RequestTimeoutHeader, middleware.GRPCTimeoutHeader}
+		})
+
+		serve := func() time.Duration {
+			h := func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+				deadline, _ = ctx.Deadline()
+				return goa.Response(ctx).Send(ctx, 200, "ok")
+			}
+			Ω(middleware.TimeoutMiddleware(spec)(h)(ctx, rw, req)).ShouldNot(HaveOccurred())
+			return deadline.Sub(time.Now())
+		}
+
+		It("honors shorter timeouts", func() {
+			req.Header.Set(middleware.RequestTimeoutHeader, "5000")
+			Ω(serve()).Should(BeNumerically("~", 5*time.Second, time.Second))
+			req.Header.Set(middleware.RequestTimeoutHeader, "2s")
+			Ω(serve()).Should(BeNumerically("~", 2*time.Second, time.Second))
+		})
+
+		It("parses gRPC timeouts", func() {
+			req.Header.Set(middleware.GRPCTimeoutHeader, "3S")
+			Ω(serve()).Should(BeNumerically("~", 3*time.Second, time.Second))
+		})
+
+		It("caps timeouts with the configured timeout", func() {
+			req.Header.Set(middleware.RequestTimeoutHeader, "1h")
+			Ω(serve()).Should(BeNumerically("~", time.Minute, time.Second))
+		})
+
+		It("caps huge timeouts without overflowing", func() {
+			req.Header.Set(middleware.GRPCTimeoutHeader, "99999999H")
+			Ω(serve()).Should(BeNumerically("~", time.Minute, time.Second))
+			req.Header.Del(middleware.GRPCTimeoutHeader)
+			req.Header.Set(middleware.RequestTimeoutHeader, "9223372036854775807")
+			Ω(serve()).Should(BeNumerically("~", time.Minute, time.Second))
+		})
+
+		It("ignores client timeouts when no timeout applies", func() {
+			spec.Routes = []*middleware.TimeoutRoute{{Path: regexp.MustCompile(`^/goo$`), Timeout: 0}}
+			req.Header.Set(middleware.RequestTimeoutHeader, "5000")
+			serve()
+			Ω(deadline.IsZero()).Should(BeTrue())
+
+			spec.Routes = nil
+			spec.Timeout = 0
+			req.Header.Set(middleware.GRPCTimeoutHeader, "99999999H")
+			serve()
+			Ω(deadline.IsZero()).Should(BeTrue())
+		})
+
+		It("ignores invalid timeouts", func() {
+			req.Header.Set(middleware.GRPCTimeoutHeader, "3X")
+			Ω(serve()).Should(BeNumerically("~", time.Minute, time.Second))
+		})
+	})
+})
+
+// panicInHandler panics, it identifies the panicking function in the reported stack frames.
+func panicInHandler() {
+	panic("boom")
+}
@@ -0,0 +1,149 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/goadesign/goa"
+
+	"golang.org/x/net/context"
+)
+
+const (
+	// TraceIDKey is the context key used by the Trace middleware to store the trace ID value.
+	TraceIDKey middlewareKey = iota + 2
+	// SpanIDKey is the context key used by the Trace middleware to store the ID of the span
+	// created for the request.
+	SpanIDKey
+	// ParentSpanIDKey is the context key used by the Trace middleware to store the ID of the
+	// caller span if any.
+	ParentSpanIDKey
+	// TraceFlagsKey is the context key used by the Trace middleware to store the trace flags.
+	TraceFlagsKey
+	// TraceStateKey is the context key used by the Trace middleware to store the vendor
+	// specific trace state if any.
+	TraceStateKey
+)
+
+const (
+	// TraceParentHeader is the name of the W3C Trace Context header that carries the trace ID,
+	// the parent span ID and the trace flags.
+	TraceParentHeader = "traceparent"
+	// TraceStateHeader is the name of the W3C Trace Context header that carries vendor specific
+	// trace data.
+	TraceStateHeader = "tracestate"
+)
+
+// traceFlagsSampled are the trace flags of the traces started by the Trace middleware.
+const traceFlagsSampled = "01"
+
+// Trace is a middleware that propagates the W3C Trace Context, see
+// https://www.w3.org/TR/trace-context/. If the incoming request has a valid traceparent header
+// then the request span is created as a child of the caller span, else a new trace is started.
+// The trace and span IDs are stored in the context under TraceIDKey and SpanIDKey and set in the
+// response traceparent header. LogRequest includes them in its log entries when mounted after
+// Trace.
+func Trace() goa.Middleware {
+	return func(h goa.Handler) goa.Handler {
+		return func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+			traceID, parentID, flags, ok := parseTraceParent(req.Header.Get(TraceParentHeader))
+			if ok {
+				ctx = context.WithValue(ctx, ParentSpanIDKey, parentID)
+				if state := req.Header.Get(TraceStateHeader); state != "" {
+					ctx = context.WithValue(ctx, TraceStateKey, state)
+				}
+			} else {
+				traceID, flags = newTraceID(), traceFlagsSampled
+			}
+			spanID := newSpanID()
+			ctx = context.WithValue(ctx, TraceIDKey, traceID)
+			ctx = context.WithValue(ctx, SpanIDKey, spanID)
+			ctx = context.WithValue(ctx, TraceFlagsKey, flags)
+
+			header := goa.Response(ctx).Header()
+			header.Set(TraceParentHeader, formatTraceParent(traceID, spanID, flags))
+			if state, ok := ctx.Value(TraceStateKey).(string); ok {
+				header.Set(TraceStateHeader, state)
+			}
+			return h(ctx, rw, req)
+		}
+	}
+}
+
+// TraceParent returns the value of the traceparent header that propagates the trace stored in
+// the context to downstream services, the request span is the parent of the downstream spans.
+// It returns an empty string if the context has no trace.
+func TraceParent(ctx context.Context) string {
+	traceID, ok := ctx.Value(TraceIDKey).(string)
+	if !ok {
+		return ""
+	}
+	spanID, _ := ctx.Value(SpanIDKey).(string)
+	flags, ok := ctx.Value(TraceFlagsKey).(string)
+	if !ok {
+		flags = traceFlagsSampled
+	}
+	return formatTraceParent(traceID, spanID, flags)
+}
+
+// parseTraceParent parses the value of a traceparent header.
+func parseTraceParent(v string) (traceID, parentID, flags string, ok bool) {
+	v = strings.TrimSpace(v)
+	// version "-" trace-id "-" parent-id "-" trace-flags
+	if len(v) < 55 || v[2] != '-' || v[35] != '-' || v[52] != '-' {
+		return
+	}
+	version := v[:2]
+	if !isLowerHex(version) || version == "ff" {
+		return
+	}
+	// Version 00 has a fixed length, future versions may append fields.
+	if version == "00" && len(v) != 55 || version != "00" && len(v) > 55 && v[55] != '-' {
+		return
+	}
+	traceID, parentID, flags = v[3:35], v[36:52], v[53:55]
+	if !isLowerHex(traceID) || !isLowerHex(parentID) || !isLowerHex(flags) {
+		return
+	}
+	if strings.Trim(traceID, "0") == "" || strings.Trim(parentID, "0") == "" {
+		return
+	}
+	ok = true
+	return
+}
+
+// formatTraceParent returns the value of a version 00 traceparent header.
+func formatTraceParent(traceID, spanID, flags string) string {
+	return fmt.Sprintf("00-%s-%s-%s", traceID, spanID, flags)
+}
+
+// isLowerHex returns true if s only contains lower case hexadecimal digits.
+func isLowerHex(s string) bool {
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// newTraceID returns a random 16 bytes trace ID.
+func newTraceID() string {
+	return randomHex(16)
+}
+
+// newSpanID returns a random 8 bytes span ID.
+func newSpanID() string {
+	return randomHex(8)
+}
+
+// randomHex returns the hexadecimal encoding of n random bytes.
+func randomHex(n int) string {
+	b := make([]byte, n)
+	io.ReadFull(rand.Reader, b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,100 @@
+package middleware_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"regexp"
+
+	"golang.org/x/net/context"
+
+	"github.com/goadesign/goa"
+	"github.com/goadesign/middleware"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Trace", func() {
+	const traceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	var req *http.Request
+	var rw *testResponseWriter
+	var ctx context.Context
+	var logger *testLogger
+	var newCtx context.Context
+
+	traceParentFormat := regexp.MustCompile(`^00-[0-9a-f]{32}-[0-9a-f]{16}-[0-9a-f]{2}$`)
+
+	BeforeEach(func() {
+		var err error
+		req, err = http.NewRequest("GET", "/goo", nil)
+		Ω(err).ShouldNot(HaveOccurred())
+		rw = new(testResponseWriter)
+		logger = new(testLogger)
+		ctx = newContext(newService(logger), rw, req, nil)
+		newCtx = nil
+	})
+
+	serve := func(mw ...goa.Middleware) {
+		var h goa.Handler = func(ctx context.Context, rw http.ResponseWriter, req *http.Request) error {
+			newCtx = ctx
+			return goa.Response(ctx).Send(ctx, 200, "ok")
+		}
+		for i := len(mw) - 1; i >= 0; i-- {
+			h = mw[i](h)
+		}
+		Ω(middleware.Trace()(h)(ctx, rw, req)).ShouldNot(HaveOccurred())
+	}
+
+	It("continues incoming traces", func() {
+		req.Header.Set("traceparent", traceParent)
+		req.Header.Set("tracestate", "vendor=value")
+		serve()
+		Ω(newCtx.Value(middleware.TraceIDKey)).Should(Equal("4bf92f3577b34da6a3ce929d0e0e4736"))
+		Ω(newCtx.Value(middleware.ParentSpanIDKey)).Should(Equal("00f067aa0ba902b7"))
+		spanID := newCtx.Value(middleware.SpanIDKey)
+		Ω(spanID).ShouldNot(Equal("00f067aa0ba902b7"))
+		Ω(rw.Header().Get("traceparent")).Should(Equal("00-4bf92f3577b34da6a3ce929d0e0e4736-" + spanID.(string) + "-01"))
+		Ω(rw.Header().Get("tracestate")).Should(Equal("vendor=value"))
+		Ω(middleware.TraceParent(newCtx)).Should(Equal(rw.Header().Get("traceparent")))
+	})
+
+	It("starts new traces", func() {
+		serve()
+		Ω(newCtx.Value(middleware.TraceIDKey)).Should(HaveLen(32))
+		Ω(newCtx.Value(middleware.ParentSpanIDKey)).Should(BeNil())
+		Ω(rw.Header().Get("traceparent")).Should(MatchRegexp(traceParentFormat.String()))
+	})
+
+	It("ignores invalid traceparent headers", func() {
+		for _, tp := range []string{
+			"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+			"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+			"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+			"garbage",
+		} {
+			req.Header.Set("traceparent", tp)
+			req.Header.Set("tracestate", "vendor=value")
+			serve()
+			Ω(newCtx.Value(middleware.TraceIDKey)).ShouldNot(Equal("4bf92f3577b34da6a3ce929d0e0e4736"))
+			Ω(newCtx.Value(middleware.TraceStateKey)).Should(BeNil())
+		}
+	})
+
+	It("adds the trace and span IDs to LogRequest entries", func() {
+		req.Header.Set("traceparent", traceParent)
+		serve(middleware.LogRequest(false))
+		Ω(logger.InfoEntries[0].Data[2]).Should(Equal("trace_id"))
+		Ω(logger.InfoEntries[0].Data[3]).Should(Equal("4bf92f3577b34da6a3ce929d0e0e4736"))
+		Ω(logger.InfoEntries[0].Data[4]).Should(Equal("span_id"))
+	})
+
+	It("adds the trace and span IDs to access logs", func() {
+		output := new(bytes.Buffer)
+		serve(middleware.LogRequestMiddleware(&middleware.LogSpecification{Format: middleware.LogFormatJSON, Output: output}))
+		var entry map[string]interface{}
+		Ω(json.Unmarshal(output.Bytes(), &entry)).ShouldNot(HaveOccurred())
+		Ω(entry["trace_id"]).Should(Equal(newCtx.Value(middleware.TraceIDKey)))
+		Ω(entry["span_id"]).Should(Equal(newCtx.Value(middleware.SpanIDKey)))
+	})
+})